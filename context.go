@@ -0,0 +1,42 @@
+package saga
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+type sagaContextKey struct{}
+
+// FromContext returns the saga which action or compensate is executed in,
+// it can be used to get result of previous steps.
+func FromContext(ctx context.Context) (*Saga, bool) {
+	s, ok := ctx.Value(sagaContextKey{}).(*Saga)
+	return s, ok
+}
+
+// detachedContext keeps values of parent context but never be cancelled or deadline exceeded.
+type detachedContext struct {
+	parent context.Context
+}
+
+// detach returns a context detached from cancellation and deadline of given ctx.
+func detach(ctx context.Context) context.Context {
+	return detachedContext{parent: ctx}
+}
+
+func (c detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (c detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (c detachedContext) Err() error {
+	return nil
+}
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
@@ -1,35 +1,124 @@
 package saga
 
 import (
-	"fmt"
 	"github.com/juju/errors"
+	"github.com/lysu/go-saga/lease"
+	"github.com/lysu/go-saga/metrics"
+	"github.com/lysu/go-saga/storage"
 	"golang.org/x/net/context"
 	"reflect"
 	"strconv"
+	"strings"
+	"time"
 )
 
-// DefaultSEC is default SEC use by package method
-var DefaultSEC ExecutionCoordinator = NewSEC()
+// DefaultSEC is default SEC use by package method, it uses package level LogStorage.
+var DefaultSEC ExecutionCoordinator = NewSEC(nil)
 
 // ExecutionCoordinator presents Saga Execution Coordinator.
 // It manages:
 // - Saga log storage.
 // - Sub-transaction definition with it's parameter info.
+// - Saga definition with it's steps.
+// - Middlewares around sub-transaction invocation.
+// - Listeners of saga events.
 type ExecutionCoordinator struct {
+	storage           storage.Storage
 	subTxDefinitions  subTxDefinitions
+	sagaDefinitions   sagaDefinitions
 	paramTypeRegister *paramTypeRegister
+	middlewares       []Middleware
+	listeners         []Listener
+	metrics           *sagaMetrics
+	tracer            Tracer
+	leases            lease.Manager
+	leaseOwner        string
+	leaseTTL          time.Duration
+}
+
+// SECOption configures optional behavior of SEC created by NewSEC.
+type SECOption func(e *ExecutionCoordinator)
+
+// WithMiddlewares adds middlewares into SEC like Use.
+func WithMiddlewares(middlewares ...Middleware) SECOption {
+	return func(e *ExecutionCoordinator) {
+		e.Use(middlewares...)
+	}
+}
+
+// WithListeners adds listeners into SEC like AddListener.
+func WithListeners(listeners ...Listener) SECOption {
+	return func(e *ExecutionCoordinator) {
+		for _, listener := range listeners {
+			e.AddListener(listener)
+		}
+	}
+}
+
+// WithTracer sets tracer of SEC like SetTracer.
+func WithTracer(tracer Tracer) SECOption {
+	return func(e *ExecutionCoordinator) {
+		e.SetTracer(tracer)
+	}
+}
+
+// WithMetrics registers metrics of SEC in reg like EnableMetrics.
+func WithMetrics(reg *metrics.Registry) SECOption {
+	return func(e *ExecutionCoordinator) {
+		e.EnableMetrics(reg)
+	}
+}
+
+// WithLeases enables leases of sagas in SEC like EnableLeases.
+func WithLeases(manager lease.Manager, owner string, ttl time.Duration) SECOption {
+	return func(e *ExecutionCoordinator) {
+		e.EnableLeases(manager, owner, ttl)
+	}
 }
 
 // NewSEC creates Saga Execution Coordinator
-// This method require supply a log Storage to save & lookup log during tx execute.
-func NewSEC() ExecutionCoordinator {
-	return ExecutionCoordinator{
+// This method require supply a log Storage to save & lookup log during tx execute,
+// package level LogStorage will be used if store is nil.
+// opts configures optional behavior like middlewares, listeners, tracer, metrics and leases.
+func NewSEC(store storage.Storage, opts ...SECOption) ExecutionCoordinator {
+	e := ExecutionCoordinator{
+		storage:          store,
 		subTxDefinitions: make(subTxDefinitions),
+		sagaDefinitions:  make(sagaDefinitions),
 		paramTypeRegister: &paramTypeRegister{
 			nameToType: make(map[string]reflect.Type),
 			typeToName: make(map[reflect.Type]string),
 		},
 	}
+	for _, opt := range opts {
+		opt(&e)
+	}
+	return e
+}
+
+// Storage returns log storage used by SEC.
+// It panics if package level storage can not be opened, Start and StartCoordinator return *StorageError instead.
+func (e *ExecutionCoordinator) Storage() storage.Storage {
+	store, err := e.logStorage()
+	if err != nil {
+		panic(err)
+	}
+	return store
+}
+
+// logStorage returns log storage used by SEC, *StorageError returns if package level storage can not be opened.
+func (e *ExecutionCoordinator) logStorage() (storage.Storage, error) {
+	store := e.storage
+	if store == nil {
+		var err error
+		if store, err = openLogStorage(); err != nil {
+			return nil, &StorageError{Op: "Open", Err: err}
+		}
+	}
+	if e.metrics != nil {
+		return instrumentedStorage{Storage: store, metrics: e.metrics}, nil
+	}
+	return store, nil
 }
 
 // AddSubTxDef create & add definition base on given subTxID, action and compensate, and return current SEC.
@@ -39,9 +128,12 @@ func NewSEC() ExecutionCoordinator {
 // action defines the action that sub-transaction will execute.
 // compensate defines the compensate that sub-transaction will execute when sage aborted.
 //
-// action and compensate MUST a function that context.Context as first argument.
-func AddSubTxDef(subTxID string, action interface{}, compensate interface{}) *ExecutionCoordinator {
-	return DefaultSEC.AddSubTxDef(subTxID, action, compensate)
+// opts configures optional behavior like retry policy.
+//
+// action and compensate MUST a function that context.Context as first argument,
+// and returns nothing, error or (T, error), it panics for other return values.
+func AddSubTxDef(subTxID string, action interface{}, compensate interface{}, opts ...SubTxOption) *ExecutionCoordinator {
+	return DefaultSEC.AddSubTxDef(subTxID, action, compensate, opts...)
 }
 
 // AddSubTxDef create & add definition base on given subTxID, action and compensate, and return current SEC.
@@ -50,11 +142,16 @@ func AddSubTxDef(subTxID string, action interface{}, compensate interface{}) *Ex
 // action defines the action that sub-transaction will execute.
 // compensate defines the compensate that sub-transaction will execute when sage aborted.
 //
-// action and compensate MUST a function that context.Context as first argument.
-func (e *ExecutionCoordinator) AddSubTxDef(subTxID string, action interface{}, compensate interface{}) *ExecutionCoordinator {
+// opts configures optional behavior like retry policy.
+//
+// action and compensate MUST a function that context.Context as first argument,
+// and returns nothing, error or (T, error), it panics for other return values.
+// Parameter and result types are persisted by type name, unnamed type like *T or []T by it's string form,
+// it panics if two different types have same name.
+func (e *ExecutionCoordinator) AddSubTxDef(subTxID string, action interface{}, compensate interface{}, opts ...SubTxOption) *ExecutionCoordinator {
 	e.paramTypeRegister.addParams(action)
 	e.paramTypeRegister.addParams(compensate)
-	e.subTxDefinitions.addDefinition(subTxID, action, compensate)
+	e.subTxDefinitions.addDefinition(subTxID, action, compensate, opts...)
 	return e
 }
 
@@ -68,6 +165,14 @@ func (e *ExecutionCoordinator) MustFindSubTxDef(subTxID string) subTxDefinition
 	return define
 }
 
+func (e *ExecutionCoordinator) findSubTxDef(subTxID string) (subTxDefinition, error) {
+	define, ok := e.subTxDefinitions.findDefinition(subTxID)
+	if !ok {
+		return define, &UnknownSubTxError{SubTxID: subTxID}
+	}
+	return define, nil
+}
+
 // MustFindParamName return param name by given reflect type.
 // Panic if param name not found.
 func (e *ExecutionCoordinator) MustFindParamName(typ reflect.Type) string {
@@ -88,19 +193,172 @@ func (e *ExecutionCoordinator) MustFindParamType(name string) reflect.Type {
 	return typ
 }
 
-func (e *ExecutionCoordinator) StartCoordinator() error {
-	logIDs, err := LogStorage().LogIDs()
+// RecoveryOutcome presents how StartCoordinator finished a saga found in log storage.
+type RecoveryOutcome int
+
+const (
+	// RecoveryCleaned flag saga had ended before, only it's leftover log was cleaned up.
+	RecoveryCleaned RecoveryOutcome = iota + 1
+	// RecoveryCompensated flag saga was aborted and all started sub-transactions were compensated.
+	RecoveryCompensated
+	// RecoveryFailed flag saga can not be driven to end, it's log is kept for next recovery.
+	RecoveryFailed
+	// RecoveryStuck flag saga is stuck since compensate still failure after retries,
+	// it's log is kept and it needs manual attention.
+	RecoveryStuck
+	// RecoveryCompleted flag saga had passed pivot, remaining steps were retried until completed.
+	RecoveryCompleted
+	// RecoverySkipped flag saga is leased by a live coordinator, it's left to the lease owner.
+	RecoverySkipped
+)
+
+func (o RecoveryOutcome) String() string {
+	switch o {
+	case RecoveryCleaned:
+		return "cleaned"
+	case RecoveryCompensated:
+		return "compensated"
+	case RecoveryFailed:
+		return "failed"
+	case RecoveryStuck:
+		return "stuck"
+	case RecoveryCompleted:
+		return "completed"
+	case RecoverySkipped:
+		return "skipped"
+	default:
+		return "unknown"
+	}
+}
+
+// RecoveryResult presents recovery outcome of one saga.
+type RecoveryResult struct {
+	SagaID  uint64
+	LogID   string
+	Outcome RecoveryOutcome
+	Err     error
+}
+
+// StartCoordinator recovers sagas left in log storage by crashed process.
+//
+// Every saga found in storage is rebuilt from it's log and driven to end:
+// sagas not ended are aborted, their started sub-transactions which are not compensated yet
+// (include interrupted compensation) are compensated, then SagaEnd is appended and log is cleaned up.
+// Stuck sagas are skipped and reported as RecoveryStuck.
+// Sagas passed pivot are not compensated, their remaining steps are retried and they are reported as RecoveryCompleted.
+// If leases are enabled, sagas leased by live owners (include in flight ones of this SEC) are skipped
+// and reported as RecoverySkipped.
+//
+// Sub-transaction definitions MUST be added to SEC before call this method.
+func (e *ExecutionCoordinator) StartCoordinator() ([]RecoveryResult, error) {
+	store, err := e.logStorage()
 	if err != nil {
-		return errors.Annotate(err, "Fetch logs failure")
+		return nil, err
 	}
+	logIDs, err := store.LogIDs()
+	if err != nil {
+		return nil, errors.Annotate(err, "Fetch logs failure")
+	}
+	results := make([]RecoveryResult, 0, len(logIDs))
 	for _, logID := range logIDs {
-		lastLogData, err := LogStorage().LastLog(logID)
+		if !strings.HasPrefix(logID, LogPrefix) {
+			continue
+		}
+		result := RecoveryResult{LogID: logID}
+		id, err := strconv.ParseUint(strings.TrimPrefix(logID, LogPrefix), 10, 64)
 		if err != nil {
-			return errors.Annotate(err, "Fetch last log panic")
+			result.Outcome = RecoveryFailed
+			result.Err = errors.Annotatef(err, "Parse saga id from %s failure", logID)
+		} else {
+			result.SagaID = id
+			result.Outcome, result.Err = e.recoverSaga(id, logID)
+		}
+		if result.Err != nil {
+			GetLogger().Warn("saga recovery failed", "sagaID", result.SagaID, "logID", logID,
+				"outcome", result.Outcome, "error", result.Err)
+		} else {
+			GetLogger().Info("saga recovered", "sagaID", result.SagaID, "logID", logID, "outcome", result.Outcome)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func (e *ExecutionCoordinator) recoverSaga(id uint64, logID string) (RecoveryOutcome, error) {
+	s := e.newSaga(context.Background(), id)
+	if err := s.acquireLease(); err != nil {
+		if err == lease.ErrLeaseHeld {
+			return RecoverySkipped, nil
 		}
-		fmt.Println(lastLogData)
+		return RecoveryFailed, err
 	}
-	return nil
+	outcome, err := s.recoverLog()
+	s.finish(err)
+	return outcome, err
+}
+
+// recoverLog rebuilds saga state from log and drives saga to end.
+func (s *Saga) recoverLog() (RecoveryOutcome, error) {
+	e, logID := s.sec, s.logID
+	logs, err := e.Storage().Lookup(logID)
+	if err != nil {
+		return RecoveryFailed, &StorageError{Op: "Lookup", LogID: logID, Err: err}
+	}
+	state, err := rebuildState(logs)
+	if err != nil {
+		return RecoveryFailed, errors.Trace(err)
+	}
+
+	if step := state.stuckStep(); step != nil {
+		return RecoveryStuck, &CompensateError{
+			SubTxID:  step.subTxID,
+			StepID:   step.stepID,
+			Attempts: step.compensateAttempts,
+			Stuck:    true,
+			Err:      errors.New(step.compensateError),
+		}
+	}
+
+	if state.ended {
+		if err := e.Storage().Cleanup(logID); err != nil {
+			return RecoveryFailed, &StorageError{Op: "Cleanup", LogID: logID, Err: err}
+		}
+		return RecoveryCleaned, nil
+	}
+
+	s.startSpan("saga.recover", state.trace)
+	return s.recoverFrom(state)
+}
+
+// recoverFrom drives saga rebuilt from log to end.
+func (s *Saga) recoverFrom(state *sagaState) (RecoveryOutcome, error) {
+	var err error
+	s.steps = len(state.steps)
+	if state.pivoted() {
+		if err := s.forward(state); err != nil {
+			return RecoveryFailed, err
+		}
+		if err := s.End(); err != nil {
+			return RecoveryFailed, err
+		}
+		return RecoveryCompleted, nil
+	}
+	s.aborted = state.aborted
+	if state.aborted {
+		err = s.rollback()
+	} else {
+		err = s.abort()
+	}
+	if cerr, ok := err.(*CompensateError); ok && cerr.Stuck {
+		return RecoveryStuck, err
+	}
+	if err != nil {
+		return RecoveryFailed, err
+	}
+	if err := s.End(); err != nil {
+		return RecoveryFailed, err
+	}
+	return RecoveryCompensated, nil
 }
 
 // StartSaga start a new saga, returns the saga was started in Default SEC.
@@ -109,15 +367,50 @@ func StartSaga(ctx context.Context, id uint64) *Saga {
 	return DefaultSEC.StartSaga(ctx, id)
 }
 
+// Start start a new saga in Default SEC like StartSaga, but returns error instead of panic.
+func Start(ctx context.Context, id uint64) (*Saga, error) {
+	return DefaultSEC.Start(ctx, id)
+}
+
 // StartSaga start a new saga, returns the saga was started.
 // This method need execute context and UNIQUE id to identify saga instance.
+// It panics when log storage failure, use Start to handle failure as error.
 func (e *ExecutionCoordinator) StartSaga(ctx context.Context, id uint64) *Saga {
+	s, err := e.Start(ctx, id)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// Start start a new saga like StartSaga, returns *StorageError when log storage failure.
+func (e *ExecutionCoordinator) Start(ctx context.Context, id uint64) (*Saga, error) {
+	if _, err := e.logStorage(); err != nil {
+		return nil, err
+	}
+	s := e.newSaga(ctx, id)
+	if err := s.acquireLease(); err != nil {
+		return nil, err
+	}
+	s.startSpan("saga")
+	if err := s.start(); err != nil {
+		s.finish(err)
+		return nil, err
+	}
+	return s, nil
+}
+
+func (e *ExecutionCoordinator) newSaga(ctx context.Context, id uint64) *Saga {
 	s := &Saga{
-		id:      id,
-		context: ctx,
-		sec:     e,
-		logID:   LogPrefix + strconv.FormatInt(int64(id), 10),
+		id:    id,
+		sec:   e,
+		logID: sagaLogID(id),
 	}
-	s.startSaga()
+	s.context = context.WithValue(ctx, sagaContextKey{}, s)
+	s.span = noopSpan{}
 	return s
 }
+
+func sagaLogID(id uint64) string {
+	return LogPrefix + strconv.FormatInt(int64(id), 10)
+}
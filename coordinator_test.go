@@ -0,0 +1,36 @@
+package saga
+
+import (
+	"testing"
+
+	"github.com/lysu/go-saga/storage"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+func TestOpenStorageFailure(t *testing.T) {
+	defer func(cfg storage.StorageConfig) {
+		StorageConfig = cfg
+	}(StorageConfig)
+	StorageConfig = storage.StorageConfig{Backend: "missing"}
+
+	sec := NewSEC(nil)
+	_, err := sec.Start(context.Background(), 1)
+	assert.IsType(t, &StorageError{}, err)
+	assert.Equal(t, "Open", err.(*StorageError).Op)
+	_, err = sec.StartCoordinator()
+	assert.IsType(t, &StorageError{}, err)
+	_, err = sec.SagaStatus(1)
+	assert.IsType(t, &StorageError{}, err)
+	assert.Panics(t, func() { sec.Storage() })
+}
+
+func TestSECOptions(t *testing.T) {
+	var tracer Tracer = noopTracer{}
+	middleware := func(next Handler) Handler { return next }
+	listener := ListenerFunc(func(event Event) {})
+	sec := NewSEC(nil, WithTracer(tracer), WithMiddlewares(middleware, middleware), WithListeners(listener))
+	assert.Equal(t, tracer, sec.tracer)
+	assert.Equal(t, 2, len(sec.middlewares))
+	assert.Equal(t, 1, len(sec.listeners))
+}
@@ -0,0 +1,337 @@
+package saga
+
+import (
+	"time"
+
+	"github.com/juju/errors"
+	"golang.org/x/net/context"
+)
+
+// StepDef presents a named step in saga definition.
+// Step executes sub-transaction for SubTxID after all steps in DependsOn completed.
+type StepDef struct {
+	Name      string
+	SubTxID   string
+	DependsOn []string
+}
+
+type sagaDefinitions map[string]*sagaDefinition
+
+type sagaDefinition struct {
+	name  string
+	steps []StepDef
+	index map[string]int
+}
+
+// newSagaDefinition validates steps and creates saga definition.
+// Steps must have unique names, known sub-transactions and dependencies without cycle.
+// Saga can have at most one pivot step, steps not required by pivot must be retriable.
+func newSagaDefinition(sec *ExecutionCoordinator, name string, steps []StepDef) (*sagaDefinition, error) {
+	def := &sagaDefinition{
+		name:  name,
+		steps: steps,
+		index: make(map[string]int, len(steps)),
+	}
+	for i, step := range steps {
+		if _, ok := def.index[step.Name]; ok {
+			return nil, errors.AlreadyExistsf("step %s in saga %s", step.Name, name)
+		}
+		if _, err := sec.findSubTxDef(step.SubTxID); err != nil {
+			return nil, err
+		}
+		def.index[step.Name] = i
+	}
+	for _, step := range steps {
+		for _, dep := range step.DependsOn {
+			if _, ok := def.index[dep]; !ok {
+				return nil, errors.NotFoundf("dependency %s of step %s in saga %s", dep, step.Name, name)
+			}
+		}
+	}
+	if def.hasCycle() {
+		return nil, errors.NotValidf("saga %s with dependency cycle", name)
+	}
+	if err := def.checkPivot(sec); err != nil {
+		return nil, err
+	}
+	return def, nil
+}
+
+// checkPivot checks steps may run after pivot completed are retriable,
+// they are steps not required by pivot directly or indirectly.
+func (d *sagaDefinition) checkPivot(sec *ExecutionCoordinator) error {
+	pivot := -1
+	for i, step := range d.steps {
+		subDef, _ := sec.findSubTxDef(step.SubTxID)
+		if subDef.kind != Pivot {
+			continue
+		}
+		if pivot >= 0 {
+			return errors.NotValidf("saga %s with more than one pivot", d.name)
+		}
+		pivot = i
+	}
+	if pivot < 0 {
+		return nil
+	}
+	deps := d.dependencies()
+	required := make(map[int]bool, len(d.steps))
+	pending := []int{pivot}
+	for len(pending) > 0 {
+		i := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		for _, dep := range deps[i] {
+			if !required[dep] {
+				required[dep] = true
+				pending = append(pending, dep)
+			}
+		}
+	}
+	for i, step := range d.steps {
+		if i == pivot || required[i] {
+			continue
+		}
+		subDef, _ := sec.findSubTxDef(step.SubTxID)
+		if subDef.kind != Retriable {
+			return errors.NotValidf("step %s may run after pivot in saga %s but not retriable", step.Name, d.name)
+		}
+	}
+	return nil
+}
+
+// hasCycle checks dependency cycle by topological sort.
+func (d *sagaDefinition) hasCycle() bool {
+	pending := make([]int, len(d.steps))
+	dependents := d.dependents()
+	ready := []int{}
+	for i, step := range d.steps {
+		pending[i] = len(step.DependsOn)
+		if pending[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+	sorted := 0
+	for len(ready) > 0 {
+		i := ready[0]
+		ready = ready[1:]
+		sorted++
+		for _, j := range dependents[i] {
+			pending[j]--
+			if pending[j] == 0 {
+				ready = append(ready, j)
+			}
+		}
+	}
+	return sorted != len(d.steps)
+}
+
+// dependencies returns indexes of steps each step depends on.
+func (d *sagaDefinition) dependencies() [][]int {
+	deps := make([][]int, len(d.steps))
+	for i, step := range d.steps {
+		for _, dep := range step.DependsOn {
+			deps[i] = append(deps[i], d.index[dep])
+		}
+	}
+	return deps
+}
+
+// dependents returns indexes of steps depend on each step.
+func (d *sagaDefinition) dependents() [][]int {
+	deps := make([][]int, len(d.steps))
+	for i, step := range d.steps {
+		for _, dep := range step.DependsOn {
+			j := d.index[dep]
+			deps[j] = append(deps[j], i)
+		}
+	}
+	return deps
+}
+
+// runDAG runs fn for given nodes concurrently, a node runs after all it's deps in nodes succeed.
+// It stops to schedule new node after first failure, and returns that failure after running nodes finished.
+func runDAG(nodes []int, deps [][]int, fn func(node int) error) error {
+	type result struct {
+		node int
+		err  error
+	}
+	included := make(map[int]bool, len(nodes))
+	for _, node := range nodes {
+		included[node] = true
+	}
+	pending := make(map[int]int, len(nodes))
+	dependents := make(map[int][]int, len(nodes))
+	for _, node := range nodes {
+		for _, dep := range deps[node] {
+			if included[dep] {
+				pending[node]++
+				dependents[dep] = append(dependents[dep], node)
+			}
+		}
+	}
+
+	results := make(chan result)
+	running := 0
+	run := func(node int) {
+		running++
+		go func() {
+			results <- result{node: node, err: fn(node)}
+		}()
+	}
+	for _, node := range nodes {
+		if pending[node] == 0 {
+			run(node)
+		}
+	}
+	var firstErr error
+	for running > 0 {
+		r := <-results
+		running--
+		if r.err != nil && firstErr == nil {
+			firstErr = r.err
+		}
+		if firstErr != nil {
+			continue
+		}
+		for _, node := range dependents[r.node] {
+			pending[node]--
+			if pending[node] == 0 {
+				run(node)
+			}
+		}
+	}
+	return firstErr
+}
+
+// AddSagaDef adds a saga definition with given name and steps into Default SEC.
+func AddSagaDef(name string, steps ...StepDef) error {
+	return DefaultSEC.AddSagaDef(name, steps...)
+}
+
+// AddSagaDef adds a saga definition with given name and steps.
+//
+// Steps constitute a DAG by DependsOn, sub-transactions of steps MUST be added to SEC before,
+// error returns when step name duplicated, sub-transaction or dependency not found, or dependencies have cycle.
+// Error also returns when saga has more than one pivot, or a non-retriable step does not precede pivot.
+func (e *ExecutionCoordinator) AddSagaDef(name string, steps ...StepDef) error {
+	def, err := newSagaDefinition(e, name, steps)
+	if err != nil {
+		return err
+	}
+	e.sagaDefinitions[name] = def
+	return nil
+}
+
+// RunSaga runs saga definition with given name in Default SEC.
+func RunSaga(ctx context.Context, id uint64, name string, args map[string][]interface{}) error {
+	return DefaultSEC.RunSaga(ctx, id, name, args)
+}
+
+// RunSaga starts a saga and executes all steps in saga definition with given name, then ends it.
+// args presents arguments of each step by step name.
+//
+// Steps are executed in topological order with maximal parallelism, a step starts once all it's dependencies completed.
+// When any action returns error or ctx is done before a step starts, saga will be aborted:
+// completed steps are compensated in reverse dependency order, then saga ends and an *ActionError or context error returned.
+// Other failures returns as Exec does, the saga is left in log storage and can be recovered by StartCoordinator.
+// Saga is not aborted after pivot step completed, it is also left for StartCoordinator to run remaining steps.
+func (e *ExecutionCoordinator) RunSaga(ctx context.Context, id uint64, name string, args map[string][]interface{}) error {
+	def, ok := e.sagaDefinitions[name]
+	if !ok {
+		return errors.NotFoundf("saga definition %s", name)
+	}
+	subDefs := make([]subTxDefinition, len(def.steps))
+	params := make([][]ParamData, len(def.steps))
+	for i, step := range def.steps {
+		subDef, err := e.findSubTxDef(step.SubTxID)
+		if err != nil {
+			return err
+		}
+		param, err := marshalParam(e, args[step.Name])
+		if err != nil {
+			return err
+		}
+		subDefs[i], params[i] = subDef, param
+	}
+
+	s := e.newSaga(ctx, id)
+	s.def = def
+	s.steps = len(def.steps)
+	if err := s.acquireLease(); err != nil {
+		return err
+	}
+	s.startSpan("saga " + name)
+	err := s.run(subDefs, params, args)
+	s.finish(err)
+	return err
+}
+
+// run logs saga start and executes steps of saga definition.
+func (s *Saga) run(subDefs []subTxDefinition, params [][]ParamData, args map[string][]interface{}) error {
+	def := s.def
+	err := s.appendLog(&Log{
+		Type:       SagaStart,
+		SagaName:   def.name,
+		Time:       time.Now(),
+		StepParams: params,
+		Trace:      s.spanContext(),
+	})
+	if err != nil {
+		return err
+	}
+
+	nodes := make([]int, len(def.steps))
+	for i := range def.steps {
+		nodes[i] = i
+	}
+	err = runDAG(nodes, def.dependencies(), func(i int) error {
+		if err := s.context.Err(); err != nil {
+			return err
+		}
+		actionErr, err := s.execStep(subDefs[i], i+1, 0, params[i], args[def.steps[i].Name], 0)
+		if err != nil {
+			return err
+		}
+		if actionErr != nil {
+			return &ActionError{SubTxID: subDefs[i].subTxID, StepID: i + 1, Err: actionErr}
+		}
+		return nil
+	})
+	if err != nil && s.isPivoted() {
+		return err
+	}
+	if _, ok := err.(*ActionError); ok || err != nil && err == s.context.Err() {
+		if abortErr := s.abort(); abortErr != nil {
+			return abortErr
+		}
+		if endErr := s.End(); endErr != nil {
+			return endErr
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	return s.End()
+}
+
+// compensateDAG compensates steps of saga definition in reverse dependency order,
+// a step is compensated after all it's dependents compensated. Failed and pivot steps are not compensated.
+func (s *Saga) compensateDAG(def *sagaDefinition, state *sagaState) error {
+	steps := make(map[int]*stepState, len(state.steps))
+	nodes := []int{}
+	for _, step := range state.steps {
+		i := step.stepID - 1
+		if i < 0 || i >= len(def.steps) {
+			return errors.NotValidf("step %d in saga %s", step.stepID, def.name)
+		}
+		if !step.needCompensate() {
+			continue
+		}
+		steps[i] = step
+		nodes = append(nodes, i)
+	}
+	return runDAG(nodes, def.dependents(), func(i int) error {
+		return s.compensate(steps[i])
+	})
+}
@@ -0,0 +1,85 @@
+package saga
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+)
+
+func TestSagaDefCycle(t *testing.T) {
+	sec := NewSEC(nil)
+	sec.AddSubTxDef("A1", T1, C1)
+
+	err := sec.AddSagaDef("ok",
+		StepDef{Name: "a", SubTxID: "A1"},
+		StepDef{Name: "b", SubTxID: "A1", DependsOn: []string{"a"}},
+		StepDef{Name: "c", SubTxID: "A1", DependsOn: []string{"a", "b"}},
+	)
+	assert.NoError(t, err)
+
+	err = sec.AddSagaDef("cycle",
+		StepDef{Name: "a", SubTxID: "A1", DependsOn: []string{"c"}},
+		StepDef{Name: "b", SubTxID: "A1", DependsOn: []string{"a"}},
+		StepDef{Name: "c", SubTxID: "A1", DependsOn: []string{"b"}},
+	)
+	assert.Error(t, err)
+
+	err = sec.AddSagaDef("missing",
+		StepDef{Name: "a", SubTxID: "A1", DependsOn: []string{"x"}},
+	)
+	assert.Error(t, err)
+
+	err = sec.AddSagaDef("unknown",
+		StepDef{Name: "a", SubTxID: "A2"},
+	)
+	assert.IsType(t, &UnknownSubTxError{}, err)
+}
+
+func TestRunDAG(t *testing.T) {
+	// 0 -> 1, 0 -> 2, (1, 2) -> 3
+	deps := [][]int{nil, {0}, {0}, {1, 2}}
+	var mu sync.Mutex
+	order := []int{}
+	err := runDAG([]int{0, 1, 2, 3}, deps, func(node int) error {
+		mu.Lock()
+		defer mu.Unlock()
+		order = append(order, node)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(order))
+	assert.Equal(t, 0, order[0])
+	assert.Equal(t, 3, order[3])
+}
+
+func TestSagaDefPivot(t *testing.T) {
+	sec := NewSEC(nil)
+	sec.AddSubTxDef("A1", T1, C1)
+	sec.AddSubTxDef("P1", T1, C1, WithKind(Pivot))
+	sec.AddSubTxDef("R1", T1, C1, WithKind(Retriable))
+
+	err := sec.AddSagaDef("ok",
+		StepDef{Name: "a", SubTxID: "A1"},
+		StepDef{Name: "p", SubTxID: "P1", DependsOn: []string{"a"}},
+		StepDef{Name: "r", SubTxID: "R1", DependsOn: []string{"p"}},
+	)
+	assert.NoError(t, err)
+
+	err = sec.AddSagaDef("after",
+		StepDef{Name: "p", SubTxID: "P1"},
+		StepDef{Name: "a", SubTxID: "A1", DependsOn: []string{"p"}},
+	)
+	assert.Error(t, err)
+
+	err = sec.AddSagaDef("concurrent",
+		StepDef{Name: "a", SubTxID: "A1"},
+		StepDef{Name: "p", SubTxID: "P1"},
+	)
+	assert.Error(t, err)
+
+	err = sec.AddSagaDef("two",
+		StepDef{Name: "p1", SubTxID: "P1"},
+		StepDef{Name: "p2", SubTxID: "P1", DependsOn: []string{"p1"}},
+	)
+	assert.Error(t, err)
+}
@@ -1,26 +1,149 @@
 package saga
 
 import (
-	"golang.org/x/net/context"
+	"fmt"
+	"math"
 	"reflect"
+	"time"
+
+	"golang.org/x/net/context"
 )
 
 type subTxDefinitions map[string]subTxDefinition
 
 type subTxDefinition struct {
-	subTxID    string
-	action     reflect.Value
-	compensate reflect.Value
+	subTxID         string
+	action          reflect.Value
+	compensate      reflect.Value
+	retry           RetryPolicy
+	compensateRetry RetryPolicy
+	timeout         time.Duration
+	kind            StepKind
+	// compensateResult flags compensate accepts action result as last argument.
+	compensateResult bool
+}
+
+// StepKind presents how a sub-transaction takes part in saga rollback.
+type StepKind int
+
+const (
+	// Compensatable flag sub-transaction can be undone by it's compensate, it's the default kind
+	Compensatable StepKind = iota
+	// Pivot flag sub-transaction is the go/no-go point of saga,
+	// saga can not be compensated after pivot completed and must go forward
+	Pivot
+	// Retriable flag sub-transaction is guaranteed to succeed eventually,
+	// it's retried until success instead of aborting saga
+	Retriable
+)
+
+const (
+	defaultRetriableBackoff    = 100 * time.Millisecond
+	defaultRetriableMaxBackoff = 30 * time.Second
+)
+
+// SubTxOption configures optional behavior of sub-transaction definition.
+type SubTxOption func(def *subTxDefinition)
+
+// WithRetry sets retry policy for sub-transaction action.
+func WithRetry(policy RetryPolicy) SubTxOption {
+	return func(def *subTxDefinition) {
+		def.retry = policy
+	}
+}
+
+// WithCompensateRetry sets retry policy for sub-transaction compensate.
+// Saga is marked as stuck when compensate still failure after retries.
+// Attempts made before recovery are counted into MaxAttempts, recovery marks saga stuck without calling compensate
+// when MaxAttempts has been used up.
+func WithCompensateRetry(policy RetryPolicy) SubTxOption {
+	return func(def *subTxDefinition) {
+		def.compensateRetry = policy
+	}
+}
+
+// WithTimeout sets timeout for each attempt of sub-transaction action and compensate,
+// the context passed to them will be done after timeout.
+func WithTimeout(timeout time.Duration) SubTxOption {
+	return func(def *subTxDefinition) {
+		def.timeout = timeout
+	}
+}
+
+// WithKind sets kind of sub-transaction, default is Compensatable.
+//
+// Compensate of Pivot sub-transaction is never called, since a failed pivot takes no effect
+// and a completed pivot can not be rolled back.
+// Action of Retriable sub-transaction is retried until success by it's retry policy,
+// MaxAttempts of policy only limits attempts in one execution or recovery and zero means no limit.
+func WithKind(kind StepKind) SubTxOption {
+	return func(def *subTxDefinition) {
+		def.kind = kind
+	}
+}
+
+// actionRetry returns retry policy of action, Retriable sub-transaction retries all errors.
+func (d subTxDefinition) actionRetry() RetryPolicy {
+	if d.kind != Retriable {
+		return d.retry
+	}
+	policy := d.retry
+	policy.Retryable = nil
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = math.MaxInt32
+	}
+	if policy.InitialBackoff <= 0 {
+		policy.InitialBackoff = defaultRetriableBackoff
+	}
+	if policy.MaxBackoff <= 0 {
+		policy.MaxBackoff = defaultRetriableMaxBackoff
+	}
+	return policy
+}
+
+// call calls fn(action or compensate) with ctx limited by timeout and args.
+// It returns result if fn returns a value besides error.
+func (d subTxDefinition) call(ctx context.Context, fn reflect.Value, args []reflect.Value) (reflect.Value, error) {
+	if d.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.timeout)
+		defer cancel()
+	}
+	params := make([]reflect.Value, 0, len(args)+1)
+	params = append(params, reflect.ValueOf(ctx))
+	params = append(params, args...)
+	result := fn.Call(params)
+	if len(result) == 2 {
+		return result[0], returnError(result[1:])
+	}
+	return reflect.Value{}, returnError(result)
+}
+
+// resultType returns type of action result, nil if action only returns error.
+func (d subTxDefinition) resultType() reflect.Type {
+	if d.action.Type().NumOut() != 2 {
+		return nil
+	}
+	return d.action.Type().Out(0)
 }
 
-func (s subTxDefinitions) addDefinition(subTxID string, action interface{}, compensate interface{}) subTxDefinitions {
+func (s subTxDefinitions) addDefinition(subTxID string, action interface{}, compensate interface{}, opts ...SubTxOption) subTxDefinitions {
 	actionMethod := subTxMethod(action)
 	compensateMethod := subTxMethod(compensate)
-	s[subTxID] = subTxDefinition{
+	def := subTxDefinition{
 		subTxID:    subTxID,
 		action:     actionMethod,
 		compensate: compensateMethod,
 	}
+	if resultType := def.resultType(); resultType != nil {
+		actionType, compensateType := actionMethod.Type(), compensateMethod.Type()
+		def.compensateResult = compensateType.NumIn() == actionType.NumIn()+1 &&
+			compensateType.In(compensateType.NumIn()-1) == resultType
+	}
+	for _, opt := range opts {
+		opt(&def)
+	}
+	s[subTxID] = def
 	return s
 }
 
@@ -38,17 +161,27 @@ func (r *paramTypeRegister) addParams(fc interface{}) {
 	funcValue := subTxMethod(fc)
 	funcType := funcValue.Type()
 	for i := 0; i < funcType.NumIn(); i++ {
-		paramType := funcType.In(i)
-		r.nameToType[paramType.Name()] = paramType
-		r.typeToName[paramType] = paramType.Name()
+		r.add(funcType.In(i))
 	}
 	for i := 0; i < funcType.NumOut(); i++ {
-		returnType := funcType.Out(i)
-		r.nameToType[returnType.Name()] = returnType
-		r.typeToName[returnType] = returnType.Name()
+		r.add(funcType.Out(i))
 	}
 }
 
+// add registers type by it's name, unnamed type like pointer, slice or map uses it's string form as name.
+// It panics if name is registered by another type, since log written by one type can not be restored to the other.
+func (r *paramTypeRegister) add(typ reflect.Type) {
+	name := typ.Name()
+	if name == "" {
+		name = typ.String()
+	}
+	if exist, ok := r.nameToType[name]; ok && exist != typ {
+		panic(fmt.Sprintf("param type name %s conflicts between %s and %s", name, exist, typ))
+	}
+	r.nameToType[name] = typ
+	r.typeToName[typ] = name
+}
+
 func (r *paramTypeRegister) findTypeName(typ reflect.Type) (string, bool) {
 	f, ok := r.typeToName[typ]
 	return f, ok
@@ -68,5 +201,23 @@ func subTxMethod(obj interface{}) reflect.Value {
 		funcValue.Type().In(0) != reflect.TypeOf((*context.Context)(nil)).Elem() {
 		panic("First argument must use context.Context.")
 	}
+	if !validReturns(funcValue.Type()) {
+		panic("Return values must be error or (T, error).")
+	}
 	return funcValue
 }
+
+// validReturns returns whether func returns error or a result with error, func without return is also allowed.
+func validReturns(funcType reflect.Type) bool {
+	errorType := reflect.TypeOf((*error)(nil)).Elem()
+	switch funcType.NumOut() {
+	case 0:
+		return true
+	case 1:
+		return funcType.Out(0) == errorType
+	case 2:
+		return funcType.Out(1) == errorType
+	default:
+		return false
+	}
+}
@@ -47,3 +47,28 @@ func TestMissFunc(t *testing.T) {
 		subTxDefinitions{}.addDefinition("Test", T1, E)
 	}()
 }
+
+func TestReturnValues(t *testing.T) {
+	ok := []interface{}{
+		T1,
+		func(ctx context.Context) error { return nil },
+		func(ctx context.Context) (string, error) { return "", nil },
+	}
+	for _, fn := range ok {
+		assert.NotPanics(t, func() { subTxDefinitions{}.addDefinition("Test", fn, C1) })
+		assert.NotPanics(t, func() { subTxDefinitions{}.addDefinition("Test", T1, fn) })
+	}
+	invalid := []interface{}{
+		func(ctx context.Context) int { return 0 },
+		func(ctx context.Context) (error, string) { return nil, "" },
+		func(ctx context.Context) (string, int, error) { return "", 0, nil },
+	}
+	for _, fn := range invalid {
+		assert.PanicsWithValue(t, "Return values must be error or (T, error).", func() {
+			subTxDefinitions{}.addDefinition("Test", fn, C1)
+		})
+		assert.PanicsWithValue(t, "Return values must be error or (T, error).", func() {
+			subTxDefinitions{}.addDefinition("Test", T1, fn)
+		})
+	}
+}
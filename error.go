@@ -1 +1,93 @@
 package saga
+
+import (
+	"fmt"
+
+	"github.com/juju/errors"
+)
+
+// ErrSagaAborted returns when execute sub-transaction in an aborted saga.
+var ErrSagaAborted = errors.New("saga has been aborted")
+
+// ErrSagaFinished returns when execute sub-transaction or end in a saga has been finished.
+var ErrSagaFinished = errors.New("saga has been finished")
+
+// ErrPivotCompleted returns when abort saga or execute non-retriable sub-transaction after pivot completed.
+var ErrPivotCompleted = errors.New("saga has passed pivot")
+
+// StorageError presents failure of saga log storage operation.
+type StorageError struct {
+	Op    string
+	LogID string
+	Err   error
+}
+
+func (e *StorageError) Error() string {
+	return fmt.Sprintf("saga log storage %s %s failure: %v", e.Op, e.LogID, e.Err)
+}
+
+// Unwrap returns underlying storage error.
+func (e *StorageError) Unwrap() error {
+	return e.Err
+}
+
+// UnknownSubTxError presents sub-transaction which is not defined in SEC.
+type UnknownSubTxError struct {
+	SubTxID string
+}
+
+func (e *UnknownSubTxError) Error() string {
+	return fmt.Sprintf("sub-transaction %s not found in SEC", e.SubTxID)
+}
+
+// ParamMarshalError presents failure of marshal or unmarshal sub-transaction parameter.
+type ParamMarshalError struct {
+	ParamType string
+	Err       error
+}
+
+func (e *ParamMarshalError) Error() string {
+	return fmt.Sprintf("marshal param %s failure: %v", e.ParamType, e.Err)
+}
+
+// Unwrap returns underlying marshal error.
+func (e *ParamMarshalError) Unwrap() error {
+	return e.Err
+}
+
+// ActionError presents failure returned by sub-transaction action,
+// the saga has been aborted and compensated when it returned.
+type ActionError struct {
+	SubTxID string
+	StepID  int
+	Err     error
+}
+
+func (e *ActionError) Error() string {
+	return fmt.Sprintf("sub-transaction %s action failure: %v", e.SubTxID, e.Err)
+}
+
+// Unwrap returns error returned by action.
+func (e *ActionError) Unwrap() error {
+	return e.Err
+}
+
+// CompensateError presents failure returned by sub-transaction compensate.
+// If Stuck is true, compensate still failure after retries and saga is left in stuck state,
+// otherwise the saga is left in log storage and can be recovered by StartCoordinator.
+type CompensateError struct {
+	SubTxID  string
+	StepID   int
+	Attempts int
+	Stuck    bool
+	Err      error
+}
+
+func (e *CompensateError) Error() string {
+	return fmt.Sprintf("sub-transaction %s compensate failure: %v", e.SubTxID, e.Err)
+}
+
+// Unwrap returns error returned by compensate.
+func (e *CompensateError) Unwrap() error {
+	return e.Err
+}
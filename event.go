@@ -0,0 +1,114 @@
+package saga
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Event presents a saga lifecycle event, it's emitted after corresponding saga log appended.
+type Event struct {
+	Type     LogType
+	SagaID   uint64
+	SagaName string
+	SubTxID  string
+	StepID   int
+	Attempt  int
+	Time     time.Time
+	// Err presents the failure for ActionRetry, ActionFailure, CompensateRetry and SagaStuck events.
+	Err error
+}
+
+// Listener receives saga events.
+// Listener is called synchronously by saga execution and MUST be safe for concurrent use,
+// wrap it by NewAsyncListener to deliver events asynchronously.
+type Listener interface {
+	OnEvent(event Event)
+}
+
+// ListenerFunc adapts a function to Listener.
+type ListenerFunc func(event Event)
+
+// OnEvent calls f(event).
+func (f ListenerFunc) OnEvent(event Event) {
+	f(event)
+}
+
+// AddListener adds listener into Default SEC.
+func AddListener(listener Listener) *ExecutionCoordinator {
+	return DefaultSEC.AddListener(listener)
+}
+
+// AddListener adds listener which receives events of all sagas in SEC, and return current SEC.
+// Events of recovery by StartCoordinator are also delivered.
+func (e *ExecutionCoordinator) AddListener(listener Listener) *ExecutionCoordinator {
+	e.listeners = append(e.listeners, listener)
+	return e
+}
+
+func (e *ExecutionCoordinator) emit(event Event) {
+	for _, listener := range e.listeners {
+		listener.OnEvent(event)
+	}
+}
+
+// AsyncListener delivers events to underlying listener in a background goroutine through a buffer.
+// Events are dropped when buffer is full, so slow listener never blocks saga execution.
+type AsyncListener struct {
+	listener Listener
+	events   chan Event
+	dropped  uint64
+	done     chan struct{}
+	once     sync.Once
+	mu       sync.RWMutex
+	closed   bool
+}
+
+// NewAsyncListener creates AsyncListener with given buffer size and starts delivering events to listener.
+func NewAsyncListener(listener Listener, buffer int) *AsyncListener {
+	l := &AsyncListener{
+		listener: listener,
+		events:   make(chan Event, buffer),
+		done:     make(chan struct{}),
+	}
+	go l.run()
+	return l
+}
+
+func (l *AsyncListener) run() {
+	defer close(l.done)
+	for event := range l.events {
+		l.listener.OnEvent(event)
+	}
+}
+
+// OnEvent puts event into buffer, it's dropped if buffer is full or listener closed.
+func (l *AsyncListener) OnEvent(event Event) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		atomic.AddUint64(&l.dropped, 1)
+		return
+	}
+	select {
+	case l.events <- event:
+	default:
+		atomic.AddUint64(&l.dropped, 1)
+	}
+}
+
+// Dropped returns number of events dropped.
+func (l *AsyncListener) Dropped() uint64 {
+	return atomic.LoadUint64(&l.dropped)
+}
+
+// Close stops accepting events and waits for buffered events delivered.
+func (l *AsyncListener) Close() {
+	l.once.Do(func() {
+		l.mu.Lock()
+		l.closed = true
+		close(l.events)
+		l.mu.Unlock()
+	})
+	<-l.done
+}
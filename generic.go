@@ -0,0 +1,37 @@
+package saga
+
+import (
+	"golang.org/x/net/context"
+)
+
+// Step presents a type-safe sub-transaction definition whose action and compensate accept Args,
+// it's created by Define and used to execute the sub-transaction with compile time checked arguments.
+type Step[Args any] struct {
+	subTxID string
+}
+
+// Define adds sub-transaction definition with typed action and compensate into given SEC, and returns it's Step.
+//
+// It shares same definition and saga log format with AddSubTxDef, Args is persisted as the only parameter.
+func Define[Args any](sec *ExecutionCoordinator, subTxID string,
+	action func(ctx context.Context, args Args) error,
+	compensate func(ctx context.Context, args Args) error,
+	opts ...SubTxOption) *Step[Args] {
+	sec.AddSubTxDef(subTxID, action, compensate, opts...)
+	return &Step[Args]{subTxID: subTxID}
+}
+
+// SubTxID returns subTxID of the step.
+func (st *Step[Args]) SubTxID() string {
+	return st.subTxID
+}
+
+// Exec executes the sub-transaction with given args in saga, it works as Saga.Exec.
+func (st *Step[Args]) Exec(s *Saga, args Args) error {
+	return s.Exec(st.subTxID, args)
+}
+
+// SubTx returns SubTx with given args for ExecParallel.
+func (st *Step[Args]) SubTx(args Args) SubTx {
+	return NewSubTx(st.subTxID, args)
+}
@@ -0,0 +1,79 @@
+// Package lease provides ownership leases with fencing tokens,
+// coordinators take lease of a saga before execute or recover it, so one saga is driven by only one coordinator.
+package lease
+
+import (
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// ErrLeaseHeld returns when acquire a lease held by live owner.
+var ErrLeaseHeld = errors.New("lease is held by live owner")
+
+// ErrLeaseLost returns when renew or release a lease which is expired and taken, or released before.
+var ErrLeaseLost = errors.New("lease has been lost")
+
+// Lease presents ownership of a key held by owner until expiry.
+type Lease struct {
+	Key   string
+	Owner string
+	// Token is fencing token, it's last token of the key plus one every time the key is acquired after free,
+	// so write with smaller token can be detected as stale.
+	Token  uint64
+	Expiry time.Time
+}
+
+// Manager manages leases.
+// A key is free when it's never acquired, it's lease expired or released.
+type Manager interface {
+	// Acquire acquires lease of key for owner with ttl.
+	// ErrLeaseHeld returns if any live owner holds it, include the given owner, use Renew to extend a held lease.
+	Acquire(key, owner string, ttl time.Duration) (Lease, error)
+	// Renew extends lease with ttl, ErrLeaseLost returns if lease is not held by it's owner with same token.
+	Renew(lease Lease, ttl time.Duration) (Lease, error)
+	// Release releases lease, ErrLeaseLost returns if lease is not held by it's owner with same token.
+	Release(lease Lease) error
+}
+
+// leaseState replays lease operations, it is shared by Manager implementations.
+type leaseState struct {
+	current  Lease
+	released bool
+}
+
+// held returns whether key is held by live owner at now.
+func (s *leaseState) held(now time.Time) bool {
+	return s.current.Owner != "" && !s.released && now.Before(s.current.Expiry)
+}
+
+// holds returns whether lease is still held at now.
+func (s *leaseState) holds(lease Lease, now time.Time) bool {
+	return !s.released && s.current.Owner == lease.Owner && s.current.Token == lease.Token && now.Before(s.current.Expiry)
+}
+
+func (s *leaseState) acquire(key, owner string, expiry, now time.Time) (Lease, bool) {
+	if s.held(now) {
+		return Lease{}, false
+	}
+	s.current.Token++
+	s.current.Key, s.current.Owner, s.current.Expiry = key, owner, expiry
+	s.released = false
+	return s.current, true
+}
+
+func (s *leaseState) renew(lease Lease, expiry, now time.Time) (Lease, bool) {
+	if !s.holds(lease, now) {
+		return Lease{}, false
+	}
+	s.current.Expiry = expiry
+	return s.current, true
+}
+
+func (s *leaseState) release(lease Lease, now time.Time) bool {
+	if !s.holds(lease, now) {
+		return false
+	}
+	s.released = true
+	return true
+}
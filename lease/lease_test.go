@@ -0,0 +1,66 @@
+package lease
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lysu/go-saga/storage/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+func testManager(t *testing.T, m Manager) {
+	ttl := 50 * time.Millisecond
+	l1, err := m.Acquire("saga_1", "a", ttl)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), l1.Token)
+
+	_, err = m.Acquire("saga_1", "b", ttl)
+	assert.Equal(t, ErrLeaseHeld, err)
+
+	_, err = m.Acquire("saga_1", "a", ttl)
+	assert.Equal(t, ErrLeaseHeld, err)
+
+	renewed, err := m.Renew(l1, ttl)
+	assert.NoError(t, err)
+	assert.True(t, !renewed.Expiry.Before(l1.Expiry))
+
+	time.Sleep(2 * ttl)
+	l2, err := m.Acquire("saga_1", "b", ttl)
+	assert.NoError(t, err)
+	assert.Equal(t, l1.Token+1, l2.Token)
+	_, err = m.Renew(l1, ttl)
+	assert.Equal(t, ErrLeaseLost, err)
+	assert.Equal(t, ErrLeaseLost, m.Release(l1))
+
+	assert.NoError(t, m.Release(l2))
+	assert.Equal(t, ErrLeaseLost, m.Release(l2))
+	l3, err := m.Acquire("saga_1", "a", ttl)
+	assert.NoError(t, err)
+	assert.Equal(t, l2.Token+1, l3.Token)
+	assert.NoError(t, m.Release(l3))
+
+	// token continues after release
+	l4, err := m.Acquire("saga_1", "b", ttl)
+	assert.NoError(t, err)
+	assert.Equal(t, l3.Token+1, l4.Token)
+	assert.NoError(t, m.Release(l4))
+}
+
+func TestMemory(t *testing.T) {
+	m := NewMemory()
+	testManager(t, m)
+	assert.Equal(t, uint64(4), m.(*memManager).leases["saga_1"].current.Token)
+}
+
+func TestStorage(t *testing.T) {
+	store := memory.New()
+	testManager(t, NewStorage(store))
+	logs, err := store.Lookup(LogPrefix + "saga_1")
+	assert.NoError(t, err)
+	assert.Equal(t, 8, len(logs))
+
+	// records are kept, so token of another manager on same store continues
+	l, err := NewStorage(store).Acquire("saga_1", "c", time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(5), l.Token)
+}
@@ -0,0 +1,57 @@
+package lease
+
+import (
+	"sync"
+	"time"
+)
+
+type memManager struct {
+	mu     sync.Mutex
+	leases map[string]*leaseState
+}
+
+// NewMemory creates an in-memory Manager, it only coordinates owners in same process.
+// State of released lease is kept, so token of next acquire continues from it.
+func NewMemory() Manager {
+	return &memManager{leases: make(map[string]*leaseState)}
+}
+
+func (m *memManager) state(key string) *leaseState {
+	s, ok := m.leases[key]
+	if !ok {
+		s = &leaseState{}
+		m.leases[key] = s
+	}
+	return s
+}
+
+func (m *memManager) Acquire(key, owner string, ttl time.Duration) (Lease, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	lease, ok := m.state(key).acquire(key, owner, now.Add(ttl), now)
+	if !ok {
+		return Lease{}, ErrLeaseHeld
+	}
+	return lease, nil
+}
+
+func (m *memManager) Renew(lease Lease, ttl time.Duration) (Lease, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	renewed, ok := m.state(lease.Key).renew(lease, now.Add(ttl), now)
+	if !ok {
+		return Lease{}, ErrLeaseLost
+	}
+	return renewed, nil
+}
+
+func (m *memManager) Release(lease Lease) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.state(lease.Key).release(lease, time.Now()) {
+		return ErrLeaseLost
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package lease
+
+import (
+	"encoding/json"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/lysu/go-saga/storage"
+)
+
+// LogPrefix is prefix of logID lease records saved under.
+const LogPrefix = "lease_"
+
+type recordOp string
+
+const (
+	opAcquire recordOp = "acquire"
+	opRenew   recordOp = "renew"
+	opRelease recordOp = "release"
+)
+
+// record presents a lease operation appended to storage.
+type record struct {
+	Op     recordOp  `json:"op"`
+	ID     string    `json:"id"`
+	Owner  string    `json:"owner"`
+	Token  uint64    `json:"token,omitempty"`
+	Time   time.Time `json:"time"`
+	Expiry time.Time `json:"expiry,omitempty"`
+}
+
+type storageManager struct {
+	store storage.Storage
+}
+
+// NewStorage creates Manager saves lease records in store, so owners sharing store are coordinated.
+//
+// Every operation is appended as a record under logID LogPrefix+key, and lease is decided by replay records
+// in storage order, so concurrent acquires are resolved by first record wins.
+// Records are never cleaned up, since token of next acquire is the last token in records plus one.
+// Owners should have roughly synchronized clocks since expiry is compared with record time.
+func NewStorage(store storage.Storage) Manager {
+	return &storageManager{store: store}
+}
+
+func (m *storageManager) Acquire(key, owner string, ttl time.Duration) (Lease, error) {
+	state, _, err := m.replay(key, "")
+	if err != nil {
+		return Lease{}, err
+	}
+	now := time.Now()
+	if state.held(now) {
+		return Lease{}, ErrLeaseHeld
+	}
+	return m.apply(key, record{Op: opAcquire, Owner: owner, Time: now, Expiry: now.Add(ttl)}, ErrLeaseHeld)
+}
+
+func (m *storageManager) Renew(lease Lease, ttl time.Duration) (Lease, error) {
+	if err := m.checkHolds(lease); err != nil {
+		return Lease{}, err
+	}
+	now := time.Now()
+	return m.apply(lease.Key, record{Op: opRenew, Owner: lease.Owner, Token: lease.Token, Time: now, Expiry: now.Add(ttl)}, ErrLeaseLost)
+}
+
+func (m *storageManager) Release(lease Lease) error {
+	if err := m.checkHolds(lease); err != nil {
+		return err
+	}
+	_, err := m.apply(lease.Key, record{Op: opRelease, Owner: lease.Owner, Token: lease.Token, Time: time.Now()}, ErrLeaseLost)
+	return err
+}
+
+// checkHolds returns ErrLeaseLost if lease is not held, so record of stale owner is not appended.
+func (m *storageManager) checkHolds(lease Lease) error {
+	state, _, err := m.replay(lease.Key, "")
+	if err != nil {
+		return err
+	}
+	if !state.holds(lease, time.Now()) {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+func newRecordID() string {
+	return strconv.FormatUint(rand.Uint64(), 36)
+}
+
+// apply appends rec and replays records to check whether it takes effect, failure returns if not.
+func (m *storageManager) apply(key string, rec record, failure error) (Lease, error) {
+	if rec.ID == "" {
+		rec.ID = newRecordID()
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return Lease{}, errors.Trace(err)
+	}
+	if err := m.store.AppendLog(LogPrefix+key, string(data)); err != nil {
+		return Lease{}, errors.Annotatef(err, "append lease record for %s", key)
+	}
+	state, ok, err := m.replay(key, rec.ID)
+	if err != nil {
+		return Lease{}, err
+	}
+	if !ok {
+		return Lease{}, failure
+	}
+	return state.current, nil
+}
+
+// replay rebuilds lease state of key from records, and returns whether record with given id took effect.
+func (m *storageManager) replay(key, id string) (*leaseState, bool, error) {
+	logs, err := m.store.Lookup(LogPrefix + key)
+	if err != nil {
+		return nil, false, errors.Annotatef(err, "lookup lease records for %s", key)
+	}
+	state := &leaseState{}
+	applied := false
+	for _, data := range logs {
+		var rec record
+		if err := json.Unmarshal([]byte(data), &rec); err != nil {
+			return nil, false, errors.Annotatef(err, "unmarshal lease record %s", data)
+		}
+		ok := false
+		switch rec.Op {
+		case opAcquire:
+			_, ok = state.acquire(key, rec.Owner, rec.Expiry, rec.Time)
+		case opRenew:
+			_, ok = state.renew(Lease{Owner: rec.Owner, Token: rec.Token}, rec.Expiry, rec.Time)
+		case opRelease:
+			ok = state.release(Lease{Owner: rec.Owner, Token: rec.Token}, rec.Time)
+		}
+		if rec.ID == id && ok {
+			applied = true
+		}
+	}
+	return state, applied, nil
+}
@@ -23,16 +23,38 @@ const (
 	CompensateStart
 	// CompensateEnd flag compensate end log
 	CompensateEnd
+	// ActionRetry flag action retry log, it records failure of previous attempt
+	ActionRetry
+	// CompensateRetry flag compensate retry log, it records failure of previous attempt
+	CompensateRetry
+	// SagaStuck flag saga can not be compensated after retries, it needs manual attention
+	SagaStuck
+	// ActionFailure flag action failure log after all attempts failed
+	ActionFailure
 )
 
 // Log presents Saga Log.
 // Saga Log used to log execute status for saga,
 // and SEC use it to compensate and retry.
 type Log struct {
-	Type    LogType     `json:"type,omitempty"`
-	SubTxID string      `json:"subTxID,omitempty"`
-	Time    time.Time   `json:"time,omitempty"`
-	Params  []ParamData `json:"params,omitempty"`
+	Type     LogType     `json:"type,omitempty"`
+	SagaName string      `json:"sagaName,omitempty"`
+	SubTxID  string      `json:"subTxID,omitempty"`
+	StepID   int         `json:"stepID,omitempty"`
+	Group    int         `json:"group,omitempty"`
+	Kind     StepKind    `json:"kind,omitempty"`
+	Time     time.Time   `json:"time,omitempty"`
+	Params   []ParamData `json:"params,omitempty"`
+	Result   *ParamData  `json:"result,omitempty"`
+	Attempt  int         `json:"attempt,omitempty"`
+	Error    string      `json:"error,omitempty"`
+	// Token is fencing token of lease held by writer, logs with stale token are ignored.
+	Token uint64 `json:"token,omitempty"`
+	// StepParams records arguments of all steps in saga definition at SagaStart,
+	// they are used to go forward after pivot in recovery.
+	StepParams [][]ParamData `json:"stepParams,omitempty"`
+	// Trace records root span of saga at SagaStart, recovery links to it.
+	Trace *SpanContext `json:"trace,omitempty"`
 }
 
 func (l *Log) mustMarshal() string {
@@ -45,6 +67,12 @@ func mustUnmarshalLog(data string) Log {
 	return log
 }
 
+func unmarshalLog(data string) (Log, error) {
+	var log Log
+	err := json.Unmarshal([]byte(data), &log)
+	return log, err
+}
+
 func mustMarshal(value interface{}) string {
 	s, err := json.Marshal(value)
 	if err != nil {
@@ -0,0 +1,125 @@
+package saga
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// Level presents severity of log message.
+type Level int
+
+const (
+	// LevelDebug flag verbose message for debugging, like every storage operation
+	LevelDebug Level = iota
+	// LevelInfo flag normal message, like saga recovered
+	LevelInfo
+	// LevelWarn flag failure which is handled, like action failed and saga aborted
+	LevelWarn
+	// LevelError flag failure needs attention, like saga stuck
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	default:
+		return fmt.Sprintf("LEVEL(%d)", int(l))
+	}
+}
+
+// Logger presents a leveled key-value logger used by engine and storage backends.
+// keyvals are alternating keys and values, like "sagaID", 1, "subTxID", "deduce".
+type Logger interface {
+	Debug(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Warn(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+}
+
+var (
+	loggerMu sync.RWMutex
+	logger   Logger = NopLogger()
+)
+
+// SetLogger sets Logger used by engine and storage backends, default Logger discards all messages.
+func SetLogger(l Logger) {
+	if l == nil {
+		l = NopLogger()
+	}
+	loggerMu.Lock()
+	defer loggerMu.Unlock()
+	logger = l
+}
+
+// GetLogger returns Logger set by SetLogger.
+func GetLogger() Logger {
+	loggerMu.RLock()
+	defer loggerMu.RUnlock()
+	return logger
+}
+
+// NopLogger returns Logger discards all messages.
+func NopLogger() Logger {
+	return nopLogger{}
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(msg string, keyvals ...interface{}) {}
+func (nopLogger) Info(msg string, keyvals ...interface{})  {}
+func (nopLogger) Warn(msg string, keyvals ...interface{})  {}
+func (nopLogger) Error(msg string, keyvals ...interface{}) {}
+
+// NewStdLogger adapts standard logger to Logger, messages below level are discarded.
+// Message is written as `LEVEL msg key=value ...`.
+func NewStdLogger(l *log.Logger, level Level) Logger {
+	return &stdLogger{logger: l, level: level}
+}
+
+type stdLogger struct {
+	logger *log.Logger
+	level  Level
+}
+
+func (l *stdLogger) Debug(msg string, keyvals ...interface{}) {
+	l.log(LevelDebug, msg, keyvals)
+}
+
+func (l *stdLogger) Info(msg string, keyvals ...interface{}) {
+	l.log(LevelInfo, msg, keyvals)
+}
+
+func (l *stdLogger) Warn(msg string, keyvals ...interface{}) {
+	l.log(LevelWarn, msg, keyvals)
+}
+
+func (l *stdLogger) Error(msg string, keyvals ...interface{}) {
+	l.log(LevelError, msg, keyvals)
+}
+
+func (l *stdLogger) log(level Level, msg string, keyvals []interface{}) {
+	if level < l.level {
+		return
+	}
+	var buf bytes.Buffer
+	buf.WriteString(level.String())
+	buf.WriteByte(' ')
+	buf.WriteString(msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		var value interface{} = "(MISSING)"
+		if i+1 < len(keyvals) {
+			value = keyvals[i+1]
+		}
+		fmt.Fprintf(&buf, " %v=%v", keyvals[i], value)
+	}
+	_ = l.logger.Output(3, buf.String())
+}
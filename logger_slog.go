@@ -0,0 +1,32 @@
+//go:build go1.21
+
+package saga
+
+import (
+	"log/slog"
+)
+
+// NewSlogLogger adapts slog.Logger to Logger, keyvals are passed as slog attributes.
+func NewSlogLogger(l *slog.Logger) Logger {
+	return slogLogger{logger: l}
+}
+
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+func (l slogLogger) Debug(msg string, keyvals ...interface{}) {
+	l.logger.Debug(msg, keyvals...)
+}
+
+func (l slogLogger) Info(msg string, keyvals ...interface{}) {
+	l.logger.Info(msg, keyvals...)
+}
+
+func (l slogLogger) Warn(msg string, keyvals ...interface{}) {
+	l.logger.Warn(msg, keyvals...)
+}
+
+func (l slogLogger) Error(msg string, keyvals ...interface{}) {
+	l.logger.Error(msg, keyvals...)
+}
@@ -0,0 +1,27 @@
+//go:build go1.21
+
+package saga
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlogLogger(t *testing.T) {
+	var buf bytes.Buffer
+	handler := slog.NewTextHandler(&buf, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})
+	l := NewSlogLogger(slog.New(handler))
+	l.Debug("hidden")
+	l.Error("saga stuck", "sagaID", 6, "subTxID", "deposit")
+	assert.Equal(t, "level=ERROR msg=\"saga stuck\" sagaID=6 subTxID=deposit\n", buf.String())
+}
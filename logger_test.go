@@ -0,0 +1,25 @@
+package saga
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStdLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewStdLogger(log.New(&buf, "", 0), LevelInfo)
+	l.Debug("retry sub-transaction", "sagaID", 1)
+	l.Warn("sub-transaction action failed", "sagaID", 1, "subTxID", "deduce", "error", errors.New("oops"))
+	l.Info("odd", "key")
+	assert.Equal(t, "WARN sub-transaction action failed sagaID=1 subTxID=deduce error=oops\n"+
+		"INFO odd key=(MISSING)\n", buf.String())
+
+	SetLogger(l)
+	assert.Equal(t, l, GetLogger())
+	SetLogger(nil)
+	assert.Equal(t, NopLogger(), GetLogger())
+}
@@ -0,0 +1,116 @@
+package saga
+
+import (
+	"time"
+
+	"github.com/lysu/go-saga/metrics"
+	"github.com/lysu/go-saga/storage"
+)
+
+// sagaMetrics records metrics of sagas in a SEC.
+type sagaMetrics struct {
+	started         *metrics.Counter
+	completed       *metrics.Counter
+	aborted         *metrics.Counter
+	stuck           *metrics.Counter
+	subTxDuration   *metrics.Histogram
+	subTxFailures   *metrics.Counter
+	storageDuration *metrics.Histogram
+}
+
+// EnableMetrics enables metrics of sagas in Default SEC.
+func EnableMetrics(reg *metrics.Registry) *ExecutionCoordinator {
+	return DefaultSEC.EnableMetrics(reg)
+}
+
+// EnableMetrics registers metrics of sagas into reg and records them during execution and recovery,
+// and return current SEC. reg.Handler() exposes them in OpenMetrics text format.
+//
+// Registered metrics are:
+// - saga_started, saga_completed, saga_aborted and saga_stuck counters.
+// - saga_subtx_duration_seconds histogram and saga_subtx_failures counter of every action and compensate attempt,
+// labeled by subtx_id and phase.
+// - saga_storage_duration_seconds histogram of log storage operations, labeled by op.
+//
+// It panics if metrics are registered in reg before, so one registry can only be used by one SEC.
+func (e *ExecutionCoordinator) EnableMetrics(reg *metrics.Registry) *ExecutionCoordinator {
+	e.metrics = &sagaMetrics{
+		started:   reg.Counter("saga_started", "Sagas started."),
+		completed: reg.Counter("saga_completed", "Sagas ended without abort."),
+		aborted:   reg.Counter("saga_aborted", "Sagas aborted."),
+		stuck:     reg.Counter("saga_stuck", "Sagas stuck since compensate failure after retries."),
+		subTxDuration: reg.Histogram("saga_subtx_duration_seconds",
+			"Duration of sub-transaction action and compensate attempts.", nil, "subtx_id", "phase"),
+		subTxFailures: reg.Counter("saga_subtx_failures",
+			"Failed sub-transaction action and compensate attempts.", "subtx_id", "phase"),
+		storageDuration: reg.Histogram("saga_storage_duration_seconds",
+			"Duration of saga log storage operations.", nil, "op"),
+	}
+	return e
+}
+
+// observeLog records saga lifecycle by appended log, aborted flags whether saga has been aborted.
+func (m *sagaMetrics) observeLog(log *Log, aborted bool) {
+	if m == nil {
+		return
+	}
+	switch log.Type {
+	case SagaStart:
+		m.started.Inc()
+	case SagaAbort:
+		m.aborted.Inc()
+	case SagaStuck:
+		m.stuck.Inc()
+	case SagaEnd:
+		if !aborted {
+			m.completed.Inc()
+		}
+	}
+}
+
+// observeInvocation records duration and failure of an action or compensate attempt.
+func (m *sagaMetrics) observeInvocation(inv *Invocation, duration time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	phase := inv.Phase.String()
+	m.subTxDuration.Observe(duration.Seconds(), inv.SubTxID, phase)
+	if err != nil {
+		m.subTxFailures.Inc(inv.SubTxID, phase)
+	}
+}
+
+func (m *sagaMetrics) observeStorage(op string, start time.Time) {
+	m.storageDuration.Observe(time.Since(start).Seconds(), op)
+}
+
+// instrumentedStorage records duration of storage operations.
+type instrumentedStorage struct {
+	storage.Storage
+	metrics *sagaMetrics
+}
+
+func (s instrumentedStorage) AppendLog(logID string, data string) error {
+	defer s.metrics.observeStorage("AppendLog", time.Now())
+	return s.Storage.AppendLog(logID, data)
+}
+
+func (s instrumentedStorage) Lookup(logID string) ([]string, error) {
+	defer s.metrics.observeStorage("Lookup", time.Now())
+	return s.Storage.Lookup(logID)
+}
+
+func (s instrumentedStorage) LogIDs() ([]string, error) {
+	defer s.metrics.observeStorage("LogIDs", time.Now())
+	return s.Storage.LogIDs()
+}
+
+func (s instrumentedStorage) Cleanup(logID string) error {
+	defer s.metrics.observeStorage("Cleanup", time.Now())
+	return s.Storage.Cleanup(logID)
+}
+
+func (s instrumentedStorage) LastLog(logID string) (string, error) {
+	defer s.metrics.observeStorage("LastLog", time.Now())
+	return s.Storage.LastLog(logID)
+}
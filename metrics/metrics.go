@@ -0,0 +1,270 @@
+// Package metrics provides a small in-process metrics registry with counters and histograms,
+// which can be exposed in OpenMetrics text format by http.Handler.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is content type of OpenMetrics text format.
+const ContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// DefaultBuckets are default histogram buckets in seconds.
+var DefaultBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Registry holds metric families and renders them.
+type Registry struct {
+	mu       sync.Mutex
+	families []family
+	names    map[string]bool
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+type family interface {
+	write(w *bufio.Writer)
+}
+
+func (r *Registry) register(name string, f family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[name] {
+		panic(fmt.Sprintf("metrics: duplicate metric %s", name))
+	}
+	r.names[name] = true
+	r.families = append(r.families, f)
+}
+
+// Counter registers a counter with given name, help and label names.
+// name should not have `_total` suffix, it's appended in exposition. It panics when name duplicated.
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	c := &Counter{vec: newVec(name, help, labels)}
+	r.register(name, c)
+	return c
+}
+
+// Histogram registers a histogram with given name, help, upper bounds of buckets and label names.
+// DefaultBuckets is used if buckets is nil. It panics when name duplicated.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	h := &Histogram{vec: newVec(name, help, labels), buckets: buckets}
+	r.register(name, h)
+	return h
+}
+
+// WriteText writes all metrics in OpenMetrics text format.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	families := append([]family(nil), r.families...)
+	r.mu.Unlock()
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		f.write(bw)
+	}
+	bw.WriteString("# EOF\n")
+	return bw.Flush()
+}
+
+// Handler returns http.Handler renders metrics in OpenMetrics text format.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		_ = r.WriteText(w)
+	})
+}
+
+// vec holds series of a metric by label values.
+type vec struct {
+	name   string
+	help   string
+	labels []string
+	mu     sync.Mutex
+	series map[string]interface{}
+}
+
+func newVec(name, help string, labels []string) vec {
+	return vec{name: name, help: help, labels: labels, series: make(map[string]interface{})}
+}
+
+// get returns series for label values, it's created by create if not exist.
+func (v *vec) get(values []string, create func() interface{}) interface{} {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	s, ok := v.series[key]
+	if !ok {
+		s = create()
+		v.series[key] = s
+	}
+	return s
+}
+
+// sortedKeys returns keys of series in order.
+func (v *vec) sortedKeys() []string {
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// labelText renders label pairs for key and extra pair.
+func (v *vec) labelText(key string, extra ...string) string {
+	pairs := []string{}
+	if len(v.labels) > 0 {
+		for i, value := range strings.Split(key, "\xff") {
+			pairs = append(pairs, v.labels[i]+`="`+escape(value)+`"`)
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escape(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (v *vec) writeHeader(w *bufio.Writer, typ string) {
+	fmt.Fprintf(w, "# TYPE %s %s\n", v.name, typ)
+	if v.help != "" {
+		fmt.Fprintf(w, "# HELP %s %s\n", v.name, escapeHelp(v.help))
+	}
+}
+
+// Counter presents a monotonically increasing value partitioned by labels.
+type Counter struct {
+	vec
+}
+
+// Inc increases counter for given label values by 1.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increases counter for given label values by delta, negative delta is ignored.
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	value := c.get(labelValues, func() interface{} { return new(float64) }).(*float64)
+	*value += delta
+}
+
+// Value returns current value for given label values.
+func (c *Counter) Value(labelValues ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	value, ok := c.series[strings.Join(labelValues, "\xff")]
+	if !ok {
+		return 0
+	}
+	return *value.(*float64)
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeHeader(w, "counter")
+	for _, key := range c.sortedKeys() {
+		fmt.Fprintf(w, "%s_total%s %s\n", c.name, c.labelText(key), formatFloat(*c.series[key].(*float64)))
+	}
+}
+
+// Histogram presents distribution of observed values in buckets partitioned by labels.
+type Histogram struct {
+	vec
+	buckets []float64
+}
+
+type histogramSeries struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Observe records value for given label values.
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.get(labelValues, func() interface{} {
+		return &histogramSeries{counts: make([]uint64, len(h.buckets))}
+	}).(*histogramSeries)
+	for i, bound := range h.buckets {
+		if value <= bound {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += value
+}
+
+// Count returns number of observed values for given label values.
+func (h *Histogram) Count(labelValues ...string) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[strings.Join(labelValues, "\xff")]
+	if !ok {
+		return 0
+	}
+	return s.(*histogramSeries).count
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.writeHeader(w, "histogram")
+	for _, key := range h.sortedKeys() {
+		s := h.series[key].(*histogramSeries)
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelText(key, "le", formatFloat(bound)), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelText(key, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelText(key), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelText(key), s.count)
+	}
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escape(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
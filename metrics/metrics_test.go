@@ -0,0 +1,48 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandler(t *testing.T) {
+	reg := NewRegistry()
+	started := reg.Counter("saga_started", "Sagas started.")
+	failures := reg.Counter("failures", "Failures by sub-transaction.", "subtx")
+	latency := reg.Histogram("latency_seconds", "", []float64{0.5, 0.1}, "op")
+
+	started.Inc()
+	started.Add(2)
+	failures.Inc(`a"b`)
+	latency.Observe(0.0625, "get")
+	latency.Observe(0.25, "get")
+	assert.Equal(t, float64(3), started.Value())
+	assert.Equal(t, uint64(2), latency.Count("get"))
+	assert.Panics(t, func() { reg.Counter("saga_started", "") })
+
+	server := httptest.NewServer(reg.Handler())
+	defer server.Close()
+	resp, err := server.Client().Get(server.URL)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, ContentType, resp.Header.Get("Content-Type"))
+	assert.Equal(t, `# TYPE saga_started counter
+# HELP saga_started Sagas started.
+saga_started_total 3
+# TYPE failures counter
+# HELP failures Failures by sub-transaction.
+failures_total{subtx="a\"b"} 1
+# TYPE latency_seconds histogram
+latency_seconds_bucket{op="get",le="0.1"} 1
+latency_seconds_bucket{op="get",le="0.5"} 2
+latency_seconds_bucket{op="get",le="+Inf"} 2
+latency_seconds_sum{op="get"} 0.3125
+latency_seconds_count{op="get"} 2
+# EOF
+`, string(body))
+}
@@ -0,0 +1,89 @@
+package saga
+
+import (
+	"reflect"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Phase presents which function of sub-transaction is invoked.
+type Phase int
+
+const (
+	// PhaseAction flag invocation of sub-transaction action
+	PhaseAction Phase = iota + 1
+	// PhaseCompensate flag invocation of sub-transaction compensate
+	PhaseCompensate
+)
+
+func (p Phase) String() string {
+	switch p {
+	case PhaseAction:
+		return "action"
+	case PhaseCompensate:
+		return "compensate"
+	default:
+		return "unknown"
+	}
+}
+
+// Invocation presents one attempt to invoke action or compensate of a sub-transaction.
+type Invocation struct {
+	SagaID  uint64
+	SubTxID string
+	StepID  int
+	Phase   Phase
+	Attempt int
+	// Args are arguments passed to action or compensate except context,
+	// compensate accepting action result gets the result as last one.
+	Args []interface{}
+}
+
+// Handler invokes action or compensate of sub-transaction for given invocation.
+type Handler func(ctx context.Context, inv *Invocation) error
+
+// Middleware wraps Handler to run code around invocation of action and compensate.
+// It can decorate ctx or returned error, or short-circuit by returning without call next.
+type Middleware func(next Handler) Handler
+
+// Use appends middlewares into Default SEC.
+func Use(middlewares ...Middleware) *ExecutionCoordinator {
+	return DefaultSEC.Use(middlewares...)
+}
+
+// Use appends middlewares which wrap every invocation of action and compensate, and return current SEC.
+//
+// Middlewares apply to both saga execution and StartCoordinator recovery, every retry attempt is invoked through them.
+// First added middleware is the outermost one.
+func (e *ExecutionCoordinator) Use(middlewares ...Middleware) *ExecutionCoordinator {
+	e.middlewares = append(e.middlewares, middlewares...)
+	return e
+}
+
+// invoke calls fn through middlewares of SEC.
+func (s *Saga) invoke(ctx context.Context, inv *Invocation, fn func(ctx context.Context) error) error {
+	handler := func(ctx context.Context, inv *Invocation) error {
+		return fn(ctx)
+	}
+	for i := len(s.sec.middlewares) - 1; i >= 0; i-- {
+		handler = s.sec.middlewares[i](handler)
+	}
+	ctx, span := s.traceInvocation(ctx, inv)
+	defer span.End()
+	start := time.Now()
+	err := handler(ctx, inv)
+	s.sec.metrics.observeInvocation(inv, time.Since(start), err)
+	if err != nil {
+		span.RecordError(err)
+	}
+	return err
+}
+
+func interfaces(values []reflect.Value) []interface{} {
+	args := make([]interface{}, 0, len(values))
+	for _, value := range values {
+		args = append(args, value.Interface())
+	}
+	return args
+}
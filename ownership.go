@@ -0,0 +1,99 @@
+package saga
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/lysu/go-saga/lease"
+)
+
+// EnableLeases enables leases of sagas in Default SEC.
+func EnableLeases(manager lease.Manager, owner string, ttl time.Duration) *ExecutionCoordinator {
+	return DefaultSEC.EnableLeases(manager, owner, ttl)
+}
+
+// EnableLeases makes SEC take lease of saga from manager as owner before execute or recover it, and return current SEC.
+// owner MUST be unique among coordinators, like host name with process ID, and ttl MUST be positive.
+//
+// Lease is renewed in background every ttl/3 and released when saga ends, or when saga is left for recovery after pivot.
+// Start and RunSaga return lease.ErrLeaseHeld if saga is leased by another owner,
+// and StartCoordinator skips sagas leased by live owners, include sagas still in flight on this SEC.
+// Every saga log carries fencing token of the lease, logs written after lease lost are ignored by recovery,
+// and saga execution returns lease.ErrLeaseLost once renewal found lease lost.
+func (e *ExecutionCoordinator) EnableLeases(manager lease.Manager, owner string, ttl time.Duration) *ExecutionCoordinator {
+	if ttl <= 0 {
+		panic(errors.NotValidf("lease ttl %v", ttl))
+	}
+	e.leases = manager
+	e.leaseOwner = owner
+	e.leaseTTL = ttl
+	return e
+}
+
+// acquireLease acquires lease of saga and starts to renew it in background.
+func (s *Saga) acquireLease() error {
+	manager := s.sec.leases
+	if manager == nil {
+		return nil
+	}
+	l, err := manager.Acquire(s.logID, s.sec.leaseOwner, s.sec.leaseTTL)
+	if err != nil {
+		return err
+	}
+	s.lease = l
+	s.leaseStop = make(chan struct{})
+	go s.renewLease(s.leaseStop)
+	return nil
+}
+
+func (s *Saga) renewLease(stop chan struct{}) {
+	interval := s.sec.leaseTTL / 3
+	if interval <= 0 {
+		interval = s.sec.leaseTTL
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		s.leaseMu.Lock()
+		l, err := s.sec.leases.Renew(s.lease, s.sec.leaseTTL)
+		if err == nil {
+			s.lease = l
+		}
+		token := s.lease.Token
+		s.leaseMu.Unlock()
+		if err == lease.ErrLeaseLost {
+			atomic.StoreInt32(&s.leaseLost, 1)
+			GetLogger().Error("saga lease lost", "sagaID", s.id, "logID", s.logID, "token", token)
+			return
+		}
+		if err != nil {
+			GetLogger().Warn("renew saga lease failure", "sagaID", s.id, "logID", s.logID, "error", err)
+		}
+	}
+}
+
+// releaseLease stops renewal and releases lease of saga.
+func (s *Saga) releaseLease() {
+	if s.leaseStop == nil {
+		return
+	}
+	close(s.leaseStop)
+	s.leaseMu.Lock()
+	defer s.leaseMu.Unlock()
+	if err := s.sec.leases.Release(s.lease); err != nil {
+		GetLogger().Warn("release saga lease failure", "sagaID", s.id, "logID", s.logID, "error", err)
+	}
+}
+
+// leaseToken returns fencing token of saga lease, zero if leases disabled.
+func (s *Saga) leaseToken() uint64 {
+	s.leaseMu.Lock()
+	defer s.leaseMu.Unlock()
+	return s.lease.Token
+}
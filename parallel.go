@@ -0,0 +1,117 @@
+package saga
+
+import (
+	"sync"
+
+	"github.com/juju/errors"
+)
+
+// SubTx presents a sub-transaction execution with it's arguments.
+type SubTx struct {
+	SubTxID string
+	Args    []interface{}
+}
+
+// NewSubTx creates SubTx for given subTxID(which define in SEC initialize) and arguments.
+func NewSubTx(subTxID string, args ...interface{}) SubTx {
+	return SubTx{SubTxID: subTxID, Args: args}
+}
+
+// ExecParallel executes given sub-transactions concurrently as a parallel group and waits for all of them.
+//
+// Every sub-transaction in group is logged as an independent step.
+// When any action returns error, saga will be aborted: the sub-transactions completed in group are
+// compensated concurrently(failed ones are not), then sub-transactions executed before group are compensated,
+// and an *ActionError for first failed sub-transaction returned.
+// Context and other failures returns as Exec does, and saga is finished after action failure passed pivot as well.
+//
+// Pivot sub-transaction can not be executed in parallel group, and only Retriable ones can be executed after pivot completed.
+func (s *Saga) ExecParallel(subTxs ...SubTx) error {
+	if s.isFinished() {
+		return ErrSagaFinished
+	}
+	if s.aborted {
+		return ErrSagaAborted
+	}
+	if err := s.checkContext(); err != nil {
+		return err
+	}
+	defs := make([]subTxDefinition, 0, len(subTxs))
+	params := make([][]ParamData, 0, len(subTxs))
+	for _, subTx := range subTxs {
+		def, err := s.sec.findSubTxDef(subTx.SubTxID)
+		if err != nil {
+			return err
+		}
+		param, err := marshalParam(s.sec, subTx.Args)
+		if err != nil {
+			return err
+		}
+		if def.kind == Pivot {
+			return errors.NotValidf("pivot sub-transaction %s in parallel group", def.subTxID)
+		}
+		if s.isPivoted() && def.kind != Retriable {
+			return ErrPivotCompleted
+		}
+		defs = append(defs, def)
+		params = append(params, param)
+	}
+
+	group := s.steps + 1
+	actionErrs := make([]error, len(subTxs))
+	errs := make([]error, len(subTxs))
+	var wg sync.WaitGroup
+	for i := range subTxs {
+		s.steps++
+		wg.Add(1)
+		go func(i, stepID int) {
+			defer wg.Done()
+			actionErrs[i], errs[i] = s.execStep(defs[i], stepID, group, params[i], subTxs[i].Args, 0)
+		}(i, s.steps)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	for i, actionErr := range actionErrs {
+		if actionErr != nil && s.isPivoted() {
+			s.failure = &ActionError{SubTxID: subTxs[i].SubTxID, StepID: group + i, Err: actionErr}
+			s.finish(actionErr)
+			return s.failure
+		}
+		if actionErr != nil {
+			if err := s.abort(); err != nil {
+				return err
+			}
+			return &ActionError{SubTxID: subTxs[i].SubTxID, StepID: group + i, Err: actionErr}
+		}
+	}
+	return nil
+}
+
+// compensateParallel compensates steps in a parallel group concurrently.
+// Steps failed in group are not compensated, and first failure is returned.
+func (s *Saga) compensateParallel(steps []*stepState) error {
+	errs := make([]error, len(steps))
+	var wg sync.WaitGroup
+	for i, step := range steps {
+		if !step.needCompensate() {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, step *stepState) {
+			defer wg.Done()
+			errs[i] = s.compensate(step)
+		}(i, step)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,7 +1,10 @@
 package saga
 
 import (
+	"encoding/json"
 	"reflect"
+
+	"github.com/juju/errors"
 )
 
 // ParamData presents sub-transaction input parameter data.
@@ -14,30 +17,59 @@ type ParamData struct {
 // MarshalParam convert args into ParamData.
 // This method will lookup typeName in given SEC.
 func MarshalParam(sec *ExecutionCoordinator, args []interface{}) []ParamData {
+	p, err := marshalParam(sec, args)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+func marshalParam(sec *ExecutionCoordinator, args []interface{}) ([]ParamData, error) {
 	p := make([]ParamData, 0, len(args))
 	for _, arg := range args {
-		typ := sec.MustFindParamName(reflect.ValueOf(arg).Type())
+		argType := reflect.ValueOf(arg).Type()
+		typ, ok := sec.paramTypeRegister.findTypeName(argType)
+		if !ok {
+			return nil, &ParamMarshalError{ParamType: argType.String(), Err: errors.NotFoundf("param type")}
+		}
+		data, err := json.Marshal(arg)
+		if err != nil {
+			return nil, &ParamMarshalError{ParamType: typ, Err: err}
+		}
 		p = append(p, ParamData{
 			ParamType: typ,
-			Data:      mustMarshal(arg),
+			Data:      string(data),
 		})
 	}
-	return p
+	return p, nil
 }
 
 // UnmarshalParam convert ParamData back to parameter values to function call usage.
 // This method will lookup reflect.Type in given SEC.
 func UnmarshalParam(sec *ExecutionCoordinator, paramData []ParamData) []reflect.Value {
+	values, err := unmarshalParam(sec, paramData)
+	if err != nil {
+		panic(err)
+	}
+	return values
+}
+
+func unmarshalParam(sec *ExecutionCoordinator, paramData []ParamData) ([]reflect.Value, error) {
 	var values []reflect.Value
 	for _, param := range paramData {
-		ptyp := sec.MustFindParamType(param.ParamType)
+		ptyp, ok := sec.paramTypeRegister.findType(param.ParamType)
+		if !ok {
+			return nil, &ParamMarshalError{ParamType: param.ParamType, Err: errors.NotFoundf("param type")}
+		}
 		obj := reflect.New(ptyp).Interface()
-		mustUnmarshal([]byte(param.Data), obj)
+		if err := json.Unmarshal([]byte(param.Data), obj); err != nil {
+			return nil, &ParamMarshalError{ParamType: param.ParamType, Err: err}
+		}
 		objV := reflect.ValueOf(obj)
 		if objV.Type().Kind() == reflect.Ptr && objV.Type() != ptyp {
 			objV = objV.Elem()
 		}
 		values = append(values, objV)
 	}
-	return values
+	return values, nil
 }
@@ -0,0 +1,69 @@
+package saga
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// RetryPolicy presents how to retry a failed sub-transaction action.
+// Zero value policy never retry.
+type RetryPolicy struct {
+	// MaxAttempts is max number of attempts include the first one.
+	MaxAttempts int
+	// InitialBackoff is wait duration before first retry.
+	InitialBackoff time.Duration
+	// MaxBackoff caps wait duration between attempts, zero means no cap.
+	MaxBackoff time.Duration
+	// Multiplier grows backoff after each attempt, default is 2.
+	Multiplier float64
+	// Jitter randomizes backoff by given fraction(0 ~ 1) to avoid retry storm.
+	Jitter float64
+	// Retryable decides whether given error can be retried, nil means all errors are retryable.
+	Retryable func(err error) bool
+}
+
+func (p RetryPolicy) shouldRetry(err error, attempt int) bool {
+	if attempt >= p.MaxAttempts {
+		return false
+	}
+	return p.Retryable == nil || p.Retryable(err)
+}
+
+// exhausted returns whether given attempts already used up retry budget, zero MaxAttempts has no budget across executions.
+func (p RetryPolicy) exhausted(attempts int) bool {
+	return p.MaxAttempts > 0 && attempts >= p.MaxAttempts
+}
+
+// backoff returns wait duration after given attempt failed.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+	backoff := float64(p.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxBackoff > 0 && backoff > float64(p.MaxBackoff) {
+		backoff = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		backoff += backoff * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(backoff)
+}
+
+// sleep waits given duration, returns false if ctx is done before that.
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,39 @@
+package saga
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	p := RetryPolicy{
+		MaxAttempts:    5,
+		InitialBackoff: 10 * time.Millisecond,
+		MaxBackoff:     30 * time.Millisecond,
+	}
+	assert.Equal(t, 10*time.Millisecond, p.backoff(1))
+	assert.Equal(t, 20*time.Millisecond, p.backoff(2))
+	assert.Equal(t, 30*time.Millisecond, p.backoff(3))
+
+	p.Jitter = 0.5
+	for i := 0; i < 10; i++ {
+		b := p.backoff(1)
+		assert.True(t, b >= 5*time.Millisecond && b <= 15*time.Millisecond)
+	}
+}
+
+func TestRetryShouldRetry(t *testing.T) {
+	assert.False(t, RetryPolicy{}.shouldRetry(fmt.Errorf("x"), 1))
+
+	p := RetryPolicy{
+		MaxAttempts: 3,
+		Retryable: func(err error) bool {
+			return err.Error() == "timeout"
+		},
+	}
+	assert.True(t, p.shouldRetry(fmt.Errorf("timeout"), 2))
+	assert.False(t, p.shouldRetry(fmt.Errorf("timeout"), 3))
+	assert.False(t, p.shouldRetry(fmt.Errorf("invalid"), 1))
+}
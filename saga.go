@@ -8,31 +8,64 @@
 package saga
 
 import (
+	"encoding/json"
 	"reflect"
 	"time"
 
+	"github.com/juju/errors"
+	"github.com/lysu/go-saga/lease"
 	"github.com/lysu/go-saga/storage"
 	"golang.org/x/net/context"
-	"log"
-	"os"
+	"sync"
+	"sync/atomic"
 )
 
 const LogPrefix = "saga_"
 
-var Logger *log.Logger
+// StorageConfig chooses backend and it's config for package level LogStorage.
+// The only registered backend is used if Backend is empty.
 var StorageConfig storage.StorageConfig
+
+// StorageProvider provides package level LogStorage if not nil,
+// otherwise storage is opened from registered backends by StorageConfig once and reused.
 var StorageProvider storage.StorageProvider
 
-func LogStorage() storage.Storage {
-	return StorageProvider(StorageConfig)
-}
+var (
+	defaultStorageMu sync.Mutex
+	defaultStorage   storage.Storage
+)
 
-func init() {
-	Logger = log.New(os.Stdout, "[Saga]", log.LstdFlags)
+// LogStorage returns storage built by package level StorageProvider and StorageConfig,
+// it's used by SEC created without storage like Default SEC.
+// It panics if storage can not be opened, SEC reports it as *StorageError instead.
+func LogStorage() storage.Storage {
+	s, err := openLogStorage()
+	if err != nil {
+		panic(err)
+	}
+	return s
 }
 
-func SetLogger(l *log.Logger) {
-	Logger = l
+// openLogStorage returns package level LogStorage, error returns if registered backend can not be opened.
+func openLogStorage() (storage.Storage, error) {
+	if StorageProvider != nil {
+		return StorageProvider(StorageConfig), nil
+	}
+	defaultStorageMu.Lock()
+	defer defaultStorageMu.Unlock()
+	if defaultStorage != nil {
+		return defaultStorage, nil
+	}
+	backend := StorageConfig.Backend
+	if backends := storage.Backends(); backend == "" && len(backends) == 1 {
+		backend = backends[0]
+	}
+	s, err := storage.Open(backend, StorageConfig.Config)
+	if err != nil {
+		return nil, err
+	}
+	defaultStorage = s
+	return s, nil
 }
 
 // Saga presents current execute transaction.
@@ -42,133 +75,616 @@ type Saga struct {
 	logID   string
 	context context.Context
 	sec     *ExecutionCoordinator
+	steps   int
+	aborted bool
+	logMu   sync.Mutex
+	// pivoted flags pivot step completed, accessed atomically.
+	pivoted int32
+	// failure presents action failure after pivot, saga is left in log storage to go forward by recovery.
+	failure error
+	// finished flags saga has been finished and it's lease released, accessed atomically.
+	finished int32
+	// span presents root span of saga.
+	span Span
+	// lease presents ownership of saga when leases enabled.
+	lease      lease.Lease
+	leaseStop  chan struct{}
+	leaseMu    sync.Mutex
+	leaseLost  int32
+	finishOnce sync.Once
+
+	// def presents saga definition when saga is run by RunSaga.
+	def      *sagaDefinition
+	results  []stepResult
+	resultMu sync.Mutex
 }
 
-func (s *Saga) startSaga() {
+// stepResult presents result returned by action of a step.
+type stepResult struct {
+	stepID  int
+	subTxID string
+	data    ParamData
+}
+
+func (s *Saga) start() error {
 	log := &Log{
-		Type: SagaStart,
-		Time: time.Now(),
+		Type:  SagaStart,
+		Time:  time.Now(),
+		Trace: s.spanContext(),
+	}
+	return s.appendLog(log)
+}
+
+func (s *Saga) appendLog(log *Log) error {
+	return s.appendFailureLog(log, nil)
+}
+
+// appendFailureLog appends log and emits it as event, cause is the failure recorded by log.
+func (s *Saga) appendFailureLog(log *Log, cause error) error {
+	if atomic.LoadInt32(&s.leaseLost) == 1 {
+		return lease.ErrLeaseLost
 	}
-	err := LogStorage().AppendLog(s.logID, log.mustMarshal())
+	log.Token = s.leaseToken()
+	store, err := s.sec.logStorage()
 	if err != nil {
-		panic("Add log Failure")
+		return err
 	}
+	s.logMu.Lock()
+	err = store.AppendLog(s.logID, log.mustMarshal())
+	s.logMu.Unlock()
+	if err != nil {
+		return &StorageError{Op: "AppendLog", LogID: s.logID, Err: err}
+	}
+	event := Event{
+		Type:     log.Type,
+		SagaID:   s.id,
+		SagaName: log.SagaName,
+		SubTxID:  log.SubTxID,
+		StepID:   log.StepID,
+		Attempt:  log.Attempt,
+		Time:     log.Time,
+		Err:      cause,
+	}
+	if s.def != nil {
+		event.SagaName = s.def.name
+	}
+	s.sec.metrics.observeLog(log, log.Type == SagaEnd && s.aborted)
+	s.sec.emit(event)
+	return nil
 }
 
 // ExecSub executes a sub-transaction for given subTxID(which define in SEC initialize) and arguments.
 // it returns current Saga.
+//
+// Saga will be aborted when action returns error, ExecSub panics when log storage or compensate failure,
+// use Exec to handle these failure as error.
+// After pivot completed, failed saga is left to StartCoordinator to go forward instead of aborted.
 func (s *Saga) ExecSub(subTxID string, args ...interface{}) *Saga {
-	subTxDef := s.sec.MustFindSubTxDef(subTxID)
+	err := s.Exec(subTxID, args...)
+	if err == nil || err == ErrSagaAborted || err == s.context.Err() {
+		return s
+	}
+	if _, ok := err.(*ActionError); ok {
+		return s
+	}
+	if err == ErrSagaFinished && s.failure != nil {
+		return s
+	}
+	panic(err)
+}
+
+// Exec executes a sub-transaction for given subTxID(which define in SEC initialize) and arguments.
+//
+// Saga will be aborted and compensated when action returns error, and an *ActionError returned.
+// Saga will also be aborted and compensated if saga context is done before execute, and context error returned.
+// Other failures returns as *UnknownSubTxError, *ParamMarshalError, *StorageError or *CompensateError.
+// ErrSagaAborted returns if saga has been aborted before.
+//
+// After a Pivot sub-transaction completed saga can not be compensated, only Retriable sub-transactions
+// can be executed and ErrPivotCompleted returns for others. Saga is not aborted when action or context failure then,
+// error returns and saga is left in log storage for StartCoordinator to retry remaining steps.
+// Saga is finished after action failure passed pivot, ErrSagaFinished returns for following execution.
+func (s *Saga) Exec(subTxID string, args ...interface{}) error {
+	if s.isFinished() {
+		return ErrSagaFinished
+	}
+	if s.aborted {
+		return ErrSagaAborted
+	}
+	if err := s.checkContext(); err != nil {
+		return err
+	}
+	subTxDef, err := s.sec.findSubTxDef(subTxID)
+	if err != nil {
+		return err
+	}
+	if s.isPivoted() && subTxDef.kind != Retriable {
+		return ErrPivotCompleted
+	}
+	params, err := marshalParam(s.sec, args)
+	if err != nil {
+		return err
+	}
+	s.steps++
+	stepID := s.steps
+	actionErr, err := s.execStep(subTxDef, stepID, 0, params, args, 0)
+	if err != nil {
+		return err
+	}
+	if actionErr != nil {
+		if s.isPivoted() {
+			s.failure = &ActionError{SubTxID: subTxID, StepID: stepID, Err: actionErr}
+			s.finish(actionErr)
+			return s.failure
+		}
+		if err := s.abort(); err != nil {
+			return err
+		}
+		return &ActionError{SubTxID: subTxID, StepID: stepID, Err: actionErr}
+	}
+	return nil
+}
+
+// execStep logs and calls action of a step, step in parallel group carries group ID.
+// prior is attempts made before for step restarted by recovery, attempts and retry budget continue from it.
+// It returns error of action as actionErr, and err when log storage failure.
+func (s *Saga) execStep(def subTxDefinition, stepID, group int, params []ParamData, args []interface{}, prior int) (actionErr error, err error) {
 	log := &Log{
 		Type:    ActionStart,
-		SubTxID: subTxID,
+		SubTxID: def.subTxID,
+		StepID:  stepID,
+		Group:   group,
+		Kind:    def.kind,
 		Time:    time.Now(),
-		Params:  MarshalParam(s.sec, args),
+		Params:  params,
+		Attempt: prior + 1,
 	}
-	err := LogStorage().AppendLog(s.logID, log.mustMarshal())
+	err = s.appendLog(log)
 	if err != nil {
-		panic("Add log Failure")
+		return nil, err
 	}
 
-	params := make([]reflect.Value, 0, len(args)+1)
-	params = append(params, reflect.ValueOf(s.context))
+	callArgs := make([]reflect.Value, 0, len(args))
 	for _, arg := range args {
-		params = append(params, reflect.ValueOf(arg))
+		callArgs = append(callArgs, reflect.ValueOf(arg))
 	}
-	result := subTxDef.action.Call(params)
-	if isReturnError(result) {
-		s.Abort()
-		return s
+	var result reflect.Value
+	inv := &Invocation{
+		SagaID:  s.id,
+		SubTxID: def.subTxID,
+		StepID:  stepID,
+		Phase:   PhaseAction,
+		Attempt: prior,
+		Args:    args,
+	}
+	call := func(ctx context.Context) error {
+		inv.Attempt++
+		return s.invoke(ctx, inv, func(ctx context.Context) error {
+			var err error
+			result, err = def.call(ctx, def.action, callArgs)
+			return err
+		})
+	}
+	retryLog := Log{
+		Type:    ActionRetry,
+		SubTxID: def.subTxID,
+		StepID:  stepID,
+	}
+	attempts, actionErr, err := s.callRetry(s.context, call, def.actionRetry(), retryLog, prior+1)
+	if err != nil {
+		return nil, err
+	}
+	if actionErr != nil {
+		log = &Log{
+			Type:    ActionFailure,
+			SubTxID: def.subTxID,
+			StepID:  stepID,
+			Time:    time.Now(),
+			Attempt: attempts,
+			Error:   actionErr.Error(),
+		}
+		GetLogger().Warn("sub-transaction action failed", "sagaID", s.id, "logID", s.logID,
+			"subTxID", def.subTxID, "stepID", stepID, "attempts", attempts, "error", actionErr)
+		return actionErr, s.appendFailureLog(log, actionErr)
 	}
 
 	log = &Log{
 		Type:    ActionEnd,
-		SubTxID: subTxID,
+		SubTxID: def.subTxID,
+		StepID:  stepID,
 		Time:    time.Now(),
 	}
-	err = LogStorage().AppendLog(s.logID, log.mustMarshal())
-	if err != nil {
-		panic("Add log Failure")
+	if result.IsValid() {
+		data, err := marshalParam(s.sec, []interface{}{result.Interface()})
+		if err != nil {
+			return nil, err
+		}
+		log.Result = &data[0]
+		s.addResult(stepResult{stepID: stepID, subTxID: def.subTxID, data: data[0]})
 	}
-	return s
+	if err := s.appendLog(log); err != nil {
+		return nil, err
+	}
+	if def.kind == Pivot {
+		atomic.StoreInt32(&s.pivoted, 1)
+	}
+	return nil, nil
+}
+
+// isFinished returns whether saga has been finished.
+func (s *Saga) isFinished() bool {
+	return atomic.LoadInt32(&s.finished) == 1
+}
+
+// isPivoted returns whether a pivot step of saga has completed.
+func (s *Saga) isPivoted() bool {
+	return atomic.LoadInt32(&s.pivoted) == 1
+}
+
+func (s *Saga) addResult(result stepResult) {
+	s.resultMu.Lock()
+	defer s.resultMu.Unlock()
+	s.results = append(s.results, result)
+}
+
+// Result unmarshals result of completed step into v.
+// name is step name for saga run by RunSaga, otherwise it's subTxID and latest result of the sub-transaction is used.
+// Only actions of form `func(ctx, args...) (T, error)` have result.
+func (s *Saga) Result(name string, v interface{}) error {
+	stepID := 0
+	if s.def != nil {
+		if i, ok := s.def.index[name]; ok {
+			stepID = i + 1
+		}
+	}
+	s.resultMu.Lock()
+	defer s.resultMu.Unlock()
+	for i := len(s.results) - 1; i >= 0; i-- {
+		result := s.results[i]
+		if stepID != 0 && result.stepID != stepID || stepID == 0 && result.subTxID != name {
+			continue
+		}
+		if err := json.Unmarshal([]byte(result.data.Data), v); err != nil {
+			return &ParamMarshalError{ParamType: result.data.ParamType, Err: err}
+		}
+		return nil
+	}
+	return errors.NotFoundf("result of %s", name)
+}
+
+// callRetry calls call with ctx and retries it by retry policy, every retry is recorded by given retryLog.
+// first is number of the first attempt, it's greater than 1 when continue attempts made before.
+// It returns attempts made and error of last attempt as callErr, and err when log storage failure.
+func (s *Saga) callRetry(ctx context.Context, call func(ctx context.Context) error, policy RetryPolicy, retryLog Log, first int) (attempts int, callErr error, err error) {
+	for attempts = first; ; attempts++ {
+		callErr = call(ctx)
+		if callErr == nil || !policy.shouldRetry(callErr, attempts) {
+			return attempts, callErr, nil
+		}
+		if !sleep(ctx, policy.backoff(attempts)) {
+			return attempts, callErr, nil
+		}
+		GetLogger().Debug("retry sub-transaction", "sagaID", s.id, "logID", s.logID,
+			"subTxID", retryLog.SubTxID, "stepID", retryLog.StepID, "attempt", attempts+1, "error", callErr)
+		retryLog.Time = time.Now()
+		retryLog.Attempt = attempts + 1
+		retryLog.Error = callErr.Error()
+		if err := s.appendFailureLog(&retryLog, callErr); err != nil {
+			return attempts, callErr, err
+		}
+	}
+}
+
+// checkContext aborts saga if saga context is done, and returns context error.
+// Saga passed pivot is not aborted.
+func (s *Saga) checkContext() error {
+	ctxErr := s.context.Err()
+	if ctxErr == nil || s.isPivoted() {
+		return ctxErr
+	}
+	if err := s.abort(); err != nil {
+		return err
+	}
+	return ctxErr
 }
 
 // EndSaga finishes a Saga's execution.
+// It panics when log storage failure, use End to handle failure as error.
 func (s *Saga) EndSaga() {
+	if err := s.End(); err != nil {
+		if _, ok := err.(*ActionError); ok {
+			return
+		}
+		panic(err)
+	}
+}
+
+// End finishes a Saga's execution, returns *StorageError when log storage failure.
+//
+// If action failed after pivot, saga is not ended and it's log is left for StartCoordinator to go forward,
+// the *ActionError of failure returns. ErrSagaFinished returns if saga has been finished before.
+func (s *Saga) End() error {
+	if s.failure != nil {
+		return s.failure
+	}
+	if s.isFinished() {
+		return ErrSagaFinished
+	}
 	log := &Log{
 		Type: SagaEnd,
 		Time: time.Now(),
 	}
-	err := LogStorage().AppendLog(s.logID, log.mustMarshal())
-	if err != nil {
-		panic("Add log Failure")
-	}
-	err = LogStorage().Cleanup(s.logID)
-	if err != nil {
-		panic("Clean up topic failure")
+	err := s.appendLog(log)
+	if err == nil {
+		if cerr := s.sec.Storage().Cleanup(s.logID); cerr != nil {
+			err = &StorageError{Op: "Cleanup", LogID: s.logID, Err: cerr}
+		}
 	}
+	s.finish(err)
+	return err
+}
+
+// finish ends root span of saga and releases it's lease once, err is recorded on span if not nil.
+func (s *Saga) finish(err error) {
+	s.finishOnce.Do(func() {
+		atomic.StoreInt32(&s.finished, 1)
+		if err != nil {
+			s.span.RecordError(err)
+		}
+		s.span.End()
+		s.releaseLease()
+	})
 }
 
 // Abort stop and compensate to rollback to start situation.
 // This method will stop continue sub-transaction and do Compensate for executed sub-transaction.
+// Compensate is called with a context detached from saga context, so it will not be cancelled with saga.
 // SubTx will call this method internal.
+// It panics when log storage or compensate failure, use Rollback to handle failure as error.
 func (s *Saga) Abort() {
-	logs, err := LogStorage().Lookup(s.logID)
-	if err != nil {
-		panic("Abort Panic")
+	if err := s.Rollback(); err != nil {
+		panic(err)
 	}
+}
+
+// Rollback stop and compensate to rollback to start situation like Abort,
+// returns *StorageError, *UnknownSubTxError, *ParamMarshalError or *CompensateError when failure.
+// ErrPivotCompleted returns and nothing is compensated if a pivot step has completed.
+func (s *Saga) Rollback() error {
+	return s.abort()
+}
+
+func (s *Saga) abort() error {
+	if s.isPivoted() {
+		return ErrPivotCompleted
+	}
+	s.aborted = true
+	s.span.SetAttributes(Attribute{Key: "saga.aborted", Value: true})
+	GetLogger().Info("saga aborted", "sagaID", s.id, "logID", s.logID)
 	alog := &Log{
 		Type: SagaAbort,
 		Time: time.Now(),
 	}
-	err = LogStorage().AppendLog(s.logID, alog.mustMarshal())
+	err := s.appendLog(alog)
+	if err != nil {
+		return err
+	}
+	return s.rollback()
+}
+
+// rollback compensates started but not yet compensated sub-transactions in reverse order.
+// Failed and pivot steps are never compensated, and saga passed pivot can not be rolled back.
+func (s *Saga) rollback() error {
+	logs, err := s.sec.Storage().Lookup(s.logID)
+	if err != nil {
+		return &StorageError{Op: "Lookup", LogID: s.logID, Err: err}
+	}
+	state, err := rebuildState(logs)
 	if err != nil {
-		panic("Add log Failure")
+		return errors.Trace(err)
+	}
+	if state.pivoted() {
+		return ErrPivotCompleted
 	}
-	for i := len(logs) - 1; i >= 0; i-- {
-		logData := logs[i]
-		log := mustUnmarshalLog(logData)
-		if log.Type == ActionStart {
-			if err := s.compensate(log); err != nil {
-				panic("Compensate Failure..")
+	if state.sagaName != "" {
+		def, ok := s.sec.sagaDefinitions[state.sagaName]
+		if !ok {
+			return errors.NotFoundf("saga definition %s", state.sagaName)
+		}
+		return s.compensateDAG(def, state)
+	}
+	for i := len(state.steps) - 1; i >= 0; {
+		step := state.steps[i]
+		if step.group == 0 {
+			i--
+			if !step.needCompensate() {
+				continue
+			}
+			if err := s.compensate(step); err != nil {
+				return err
 			}
+			continue
+		}
+		j := i
+		for j >= 0 && state.steps[j].group == step.group {
+			j--
+		}
+		if err := s.compensateParallel(state.steps[j+1 : i+1]); err != nil {
+			return err
 		}
+		i = j
 	}
+	return nil
 }
 
-func (s *Saga) compensate(tlog Log) error {
+// forward drives saga passed pivot to end by retrying steps not yet completed.
+// Remaining steps of saga run by RunSaga are also executed with arguments logged at saga start.
+// Results of completed steps are restored first, so remaining steps can read them by Result.
+func (s *Saga) forward(state *sagaState) error {
+	atomic.StoreInt32(&s.pivoted, 1)
+	for _, step := range state.steps {
+		if step.actionEnded && step.result != nil {
+			s.addResult(stepResult{stepID: step.stepID, subTxID: step.subTxID, data: *step.result})
+		}
+	}
+	if state.sagaName == "" {
+		for _, step := range state.steps {
+			if step.actionEnded {
+				continue
+			}
+			if err := s.retryStep(step.stepID, step.subTxID, step.group, step.params, step.attempts); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	def, ok := s.sec.sagaDefinitions[state.sagaName]
+	if !ok {
+		return errors.NotFoundf("saga definition %s", state.sagaName)
+	}
+	if len(state.stepParams) != len(def.steps) {
+		return errors.NotValidf("step arguments of saga %s", state.sagaName)
+	}
+	s.def = def
+	started := make(map[int]*stepState, len(state.steps))
+	for _, step := range state.steps {
+		started[step.stepID-1] = step
+	}
+	nodes := []int{}
+	for i := range def.steps {
+		if step, ok := started[i]; !ok || !step.actionEnded {
+			nodes = append(nodes, i)
+		}
+	}
+	return runDAG(nodes, def.dependencies(), func(i int) error {
+		prior := 0
+		if step, ok := started[i]; ok {
+			prior = step.attempts
+		}
+		return s.retryStep(i+1, def.steps[i].SubTxID, 0, state.stepParams[i], prior)
+	})
+}
+
+// retryStep executes action of step again with logged arguments, prior is attempts made before.
+func (s *Saga) retryStep(stepID int, subTxID string, group int, params []ParamData, prior int) error {
+	def, err := s.sec.findSubTxDef(subTxID)
+	if err != nil {
+		return err
+	}
+	values, err := unmarshalParam(s.sec, params)
+	if err != nil {
+		return err
+	}
+	actionErr, err := s.execStep(def, stepID, group, params, interfaces(values), prior)
+	if err != nil {
+		return err
+	}
+	if actionErr != nil {
+		return &ActionError{SubTxID: subTxID, StepID: stepID, Err: actionErr}
+	}
+	return nil
+}
+
+func (s *Saga) compensate(step *stepState) error {
+	subDef, err := s.sec.findSubTxDef(step.subTxID)
+	if err != nil {
+		return err
+	}
+	args, err := unmarshalParam(s.sec, step.params)
+	if err != nil {
+		return err
+	}
+	if subDef.compensateResult {
+		result := reflect.Zero(subDef.resultType())
+		if step.result != nil {
+			values, err := unmarshalParam(s.sec, []ParamData{*step.result})
+			if err != nil {
+				return err
+			}
+			result = values[0]
+		}
+		args = append(args, result)
+	}
+
+	// attempts made before recovery are counted into retry budget
+	prior := step.compensateAttempts
+	if subDef.compensateRetry.exhausted(prior) {
+		compensateErr := errors.Errorf("compensate attempts used up, last error: %s", step.compensateError)
+		return s.stuck(step, prior, compensateErr)
+	}
+
 	clog := &Log{
 		Type:    CompensateStart,
-		SubTxID: tlog.SubTxID,
+		SubTxID: step.subTxID,
+		StepID:  step.stepID,
 		Time:    time.Now(),
+		Attempt: prior + 1,
 	}
-	err := LogStorage().AppendLog(s.logID, clog.mustMarshal())
+	err = s.appendLog(clog)
 	if err != nil {
-		panic("Add log Failure")
+		return err
 	}
 
-	args := UnmarshalParam(s.sec, tlog.Params)
-
-	params := make([]reflect.Value, 0, len(args)+1)
-	params = append(params, reflect.ValueOf(s.context))
-	params = append(params, args...)
-
-	subDef := s.sec.MustFindSubTxDef(tlog.SubTxID)
-	result := subDef.compensate.Call(params)
-	if isReturnError(result) {
-		s.Abort()
+	inv := &Invocation{
+		SagaID:  s.id,
+		SubTxID: step.subTxID,
+		StepID:  step.stepID,
+		Attempt: prior,
+		Phase:   PhaseCompensate,
+		Args:    interfaces(args),
+	}
+	call := func(ctx context.Context) error {
+		inv.Attempt++
+		return s.invoke(ctx, inv, func(ctx context.Context) error {
+			_, err := subDef.call(ctx, subDef.compensate, args)
+			return err
+		})
+	}
+	retryLog := Log{
+		Type:    CompensateRetry,
+		SubTxID: step.subTxID,
+		StepID:  step.stepID,
+	}
+	attempts, compensateErr, err := s.callRetry(detach(s.context), call, subDef.compensateRetry, retryLog, prior+1)
+	if err != nil {
+		return err
+	}
+	if compensateErr != nil {
+		return s.stuck(step, attempts, compensateErr)
 	}
 
 	clog = &Log{
 		Type:    CompensateEnd,
-		SubTxID: tlog.SubTxID,
+		SubTxID: step.subTxID,
+		StepID:  step.stepID,
 		Time:    time.Now(),
 	}
-	err = LogStorage().AppendLog(s.logID, clog.mustMarshal())
-	if err != nil {
-		panic("Add log Failure")
+	return s.appendLog(clog)
+}
+
+// stuck marks saga stuck by step's compensate failure, and returns stuck CompensateError.
+func (s *Saga) stuck(step *stepState, attempts int, compensateErr error) error {
+	stuckLog := &Log{
+		Type:    SagaStuck,
+		SubTxID: step.subTxID,
+		StepID:  step.stepID,
+		Time:    time.Now(),
+		Attempt: attempts,
+		Error:   compensateErr.Error(),
+	}
+	GetLogger().Error("saga stuck", "sagaID", s.id, "logID", s.logID,
+		"subTxID", step.subTxID, "stepID", step.stepID, "attempts", attempts, "error", compensateErr)
+	if err := s.appendFailureLog(stuckLog, compensateErr); err != nil {
+		return err
+	}
+	return &CompensateError{
+		SubTxID:  step.subTxID,
+		StepID:   step.stepID,
+		Attempts: attempts,
+		Err:      compensateErr,
+		Stuck:    true,
 	}
-	return nil
 }
 
 func isReturnError(result []reflect.Value) bool {
@@ -177,3 +693,13 @@ func isReturnError(result []reflect.Value) bool {
 	}
 	return false
 }
+
+func returnError(result []reflect.Value) error {
+	if !isReturnError(result) {
+		return nil
+	}
+	if err, ok := result[0].Interface().(error); ok {
+		return err
+	}
+	return errors.Errorf("%v", result[0].Interface())
+}
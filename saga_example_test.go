@@ -5,7 +5,8 @@ import (
 	"time"
 
 	"github.com/lysu/go-saga"
-	_ "github.com/lysu/go-saga/storage/kafka"
+	"github.com/lysu/go-saga/storage"
+	"github.com/lysu/go-saga/storage/kafka"
 )
 
 // This example show how to initialize an Saga execution coordinator(SEC) and add Sub-transaction to it, then start a transfer transaction.
@@ -31,11 +32,16 @@ func Example_sagaTransaction() {
 	}
 
 	// 2. Init SEC as global SINGLETON(this demo not..), and add Sub-transaction definition into SEC.
-	saga.StorageConfig.Kafka.ZkAddrs = []string{"0.0.0.0:2181"}
-	saga.StorageConfig.Kafka.BrokerAddrs = []string{"0.0.0.0:9092"}
-	saga.StorageConfig.Kafka.Partitions = 1
-	saga.StorageConfig.Kafka.Replicas = 1
-	saga.StorageConfig.Kafka.ReturnDuration = 50 * time.Millisecond
+	saga.StorageConfig = storage.StorageConfig{
+		Backend: "kafka",
+		Config: kafka.Config{
+			ZkAddrs:        []string{"0.0.0.0:2181"},
+			BrokerAddrs:    []string{"0.0.0.0:9092"},
+			Partitions:     1,
+			Replicas:       1,
+			ReturnDuration: 50 * time.Millisecond,
+		},
+	}
 
 	saga.AddSubTxDef("deduce", DeduceAccount, CompensateDeduce).
 		AddSubTxDef("deposit", DepositAccount, CompensateDeposit)
@@ -0,0 +1,178 @@
+package saga
+
+import (
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// stepState presents execute status of one sub-transaction in a saga,
+// it is rebuilt from saga log.
+type stepState struct {
+	stepID             int
+	subTxID            string
+	group              int
+	kind               StepKind
+	params             []ParamData
+	result             *ParamData
+	attempts           int
+	actionError        string
+	actionEnded        bool
+	actionFailed       bool
+	compensateStarted  bool
+	compensateEnded    bool
+	compensateAttempts int
+	compensateError    string
+	stuck              bool
+	startTime          time.Time
+	endTime            time.Time
+	compensateTime     time.Time
+	compensateEndTime  time.Time
+}
+
+// sagaState presents execute status of a saga rebuilt from saga log.
+type sagaState struct {
+	sagaName   string
+	stepParams [][]ParamData
+	trace      SpanContext
+	steps      []*stepState
+	aborted    bool
+	ended      bool
+	startTime  time.Time
+	endTime    time.Time
+}
+
+// rebuildState replays saga logs to rebuild saga state.
+func rebuildState(logs []string) (*sagaState, error) {
+	state := &sagaState{}
+	var token uint64
+	for _, logData := range logs {
+		log, err := unmarshalLog(logData)
+		if err != nil {
+			return nil, errors.Annotatef(err, "Unmarshal log %s failure", logData)
+		}
+		if log.Token < token {
+			// written by coordinator lost it's lease
+			continue
+		}
+		token = log.Token
+		switch log.Type {
+		case SagaStart:
+			state.sagaName = log.SagaName
+			state.stepParams = log.StepParams
+			if log.Trace != nil {
+				state.trace = *log.Trace
+			}
+			state.startTime = log.Time
+		case SagaAbort:
+			state.aborted = true
+		case SagaEnd:
+			state.ended = true
+			state.endTime = log.Time
+		case ActionStart:
+			step := &stepState{
+				stepID:    log.StepID,
+				subTxID:   log.SubTxID,
+				group:     log.Group,
+				kind:      log.Kind,
+				params:    log.Params,
+				attempts:  log.Attempt,
+				startTime: log.Time,
+			}
+			if step.attempts == 0 {
+				step.attempts = 1
+			}
+			if prev := state.findStep(log); log.StepID != 0 && prev != nil {
+				// step restarted by forward recovery, attempts continue from logged ones
+				*prev = *step
+				continue
+			}
+			state.steps = append(state.steps, step)
+		case ActionRetry:
+			if step := state.findStep(log); step != nil {
+				step.attempts = log.Attempt
+				step.actionError = log.Error
+			}
+		case ActionFailure:
+			if step := state.findStep(log); step != nil {
+				step.actionFailed = true
+				step.attempts = log.Attempt
+				step.actionError = log.Error
+				step.endTime = log.Time
+			}
+		case ActionEnd:
+			if step := state.findStep(log); step != nil {
+				step.actionEnded = true
+				step.result = log.Result
+				step.endTime = log.Time
+			}
+		case CompensateStart:
+			if step := state.findStep(log); step != nil {
+				step.compensateStarted = true
+				step.compensateAttempts = 1
+				if log.Attempt > 0 {
+					step.compensateAttempts = log.Attempt
+				}
+				step.compensateTime = log.Time
+			}
+		case CompensateRetry:
+			if step := state.findStep(log); step != nil {
+				step.compensateAttempts = log.Attempt
+				step.compensateError = log.Error
+			}
+		case SagaStuck:
+			if step := state.findStep(log); step != nil {
+				step.stuck = true
+				step.compensateAttempts = log.Attempt
+				step.compensateError = log.Error
+			}
+		case CompensateEnd:
+			if step := state.findStep(log); step != nil {
+				step.compensateEnded = true
+				step.compensateEndTime = log.Time
+			}
+		}
+	}
+	return state, nil
+}
+
+// findStep finds the step given log belongs to.
+// Log without stepID is matched to latest step with same subTxID.
+func (s *sagaState) findStep(log Log) *stepState {
+	for i := len(s.steps) - 1; i >= 0; i-- {
+		step := s.steps[i]
+		if log.StepID != 0 && step.stepID == log.StepID {
+			return step
+		}
+		if log.StepID == 0 && step.subTxID == log.SubTxID {
+			return step
+		}
+	}
+	return nil
+}
+
+// stuckStep returns the step made saga stuck.
+func (s *sagaState) stuckStep() *stepState {
+	for _, step := range s.steps {
+		if step.stuck {
+			return step
+		}
+	}
+	return nil
+}
+
+// pivoted returns whether a pivot step has completed, saga must go forward after that.
+func (s *sagaState) pivoted() bool {
+	for _, step := range s.steps {
+		if step.kind == Pivot && step.actionEnded {
+			return true
+		}
+	}
+	return false
+}
+
+// needCompensate returns whether step should be compensated in rollback.
+// Compensated, failed and pivot steps take no effect to roll back, started but not ended steps are compensated.
+func (s *stepState) needCompensate() bool {
+	return !s.compensateEnded && !s.actionFailed && s.kind != Pivot
+}
@@ -0,0 +1,46 @@
+package saga
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRebuildState(t *testing.T) {
+	logs := []string{
+		(&Log{Type: SagaStart}).mustMarshal(),
+		(&Log{Type: ActionStart, SubTxID: "A1", StepID: 1}).mustMarshal(),
+		(&Log{Type: ActionEnd, SubTxID: "A1", StepID: 1}).mustMarshal(),
+		(&Log{Type: ActionStart, SubTxID: "A1", StepID: 2, Attempt: 1}).mustMarshal(),
+		(&Log{Type: ActionRetry, SubTxID: "A1", StepID: 2, Attempt: 2}).mustMarshal(),
+		(&Log{Type: SagaAbort}).mustMarshal(),
+		(&Log{Type: CompensateStart, SubTxID: "A1", StepID: 2}).mustMarshal(),
+		(&Log{Type: CompensateEnd, SubTxID: "A1", StepID: 2}).mustMarshal(),
+		(&Log{Type: CompensateStart, SubTxID: "A1", StepID: 1}).mustMarshal(),
+	}
+	state, err := rebuildState(logs)
+	assert.NoError(t, err)
+	assert.True(t, state.aborted)
+	assert.False(t, state.ended)
+	assert.Equal(t, 2, len(state.steps))
+	assert.True(t, state.steps[0].actionEnded)
+	assert.True(t, state.steps[0].compensateStarted)
+	assert.False(t, state.steps[0].compensateEnded)
+	assert.False(t, state.steps[1].actionEnded)
+	assert.Equal(t, 2, state.steps[1].attempts)
+	assert.True(t, state.steps[1].compensateEnded)
+}
+
+func TestRebuildStateFencing(t *testing.T) {
+	logs := []string{
+		(&Log{Type: SagaStart, Token: 1}).mustMarshal(),
+		(&Log{Type: ActionStart, SubTxID: "A1", StepID: 1, Token: 1}).mustMarshal(),
+		(&Log{Type: SagaAbort, Token: 2}).mustMarshal(),
+		(&Log{Type: ActionEnd, SubTxID: "A1", StepID: 1, Token: 1}).mustMarshal(),
+		(&Log{Type: CompensateStart, SubTxID: "A1", StepID: 1, Token: 2}).mustMarshal(),
+	}
+	state, err := rebuildState(logs)
+	assert.NoError(t, err)
+	assert.True(t, state.aborted)
+	assert.False(t, state.steps[0].actionEnded)
+	assert.True(t, state.steps[0].compensateStarted)
+}
@@ -0,0 +1,169 @@
+package saga
+
+import (
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// SagaState presents overall state of a saga.
+type SagaState int
+
+const (
+	// StateRunning flag saga is executing sub-transactions
+	StateRunning SagaState = iota + 1
+	// StateCompleted flag saga ended without abort
+	StateCompleted
+	// StateAborting flag saga is aborted and compensating
+	StateAborting
+	// StateCompensated flag saga is aborted and all sub-transactions are compensated
+	StateCompensated
+	// StateStuck flag saga can not be compensated after retries
+	StateStuck
+)
+
+// StepState presents state of a step in saga.
+type StepState int
+
+const (
+	// StepRunning flag step action is executing
+	StepRunning StepState = iota + 1
+	// StepCompleted flag step action completed
+	StepCompleted
+	// StepFailed flag step action failed after all attempts
+	StepFailed
+	// StepCompensating flag step compensate is executing
+	StepCompensating
+	// StepCompensated flag step compensate completed
+	StepCompensated
+	// StepStuck flag step compensate failed after retries
+	StepStuck
+)
+
+// Status presents structured view of saga status reconstructed from saga log.
+type Status struct {
+	SagaID   uint64
+	SagaName string
+	State    SagaState
+	Start    time.Time
+	End      time.Time
+	Steps    []StepStatus
+}
+
+// StepStatus presents status of a step in saga.
+type StepStatus struct {
+	StepID  int
+	Name    string
+	SubTxID string
+	Kind    StepKind
+	State   StepState
+	Params  []ParamData
+	Result  *ParamData
+
+	Attempts int
+	Error    string
+	Start    time.Time
+	End      time.Time
+
+	CompensateAttempts int
+	CompensateError    string
+	CompensateStart    time.Time
+	CompensateEnd      time.Time
+}
+
+// SagaStatus returns status of saga with given id in Default SEC.
+func SagaStatus(id uint64) (*Status, error) {
+	return DefaultSEC.SagaStatus(id)
+}
+
+// SagaStatus returns status of saga with given id, it is reconstructed from saga log.
+// NotFound error returns if there is no log for saga, since log is cleaned up after saga ended.
+func (e *ExecutionCoordinator) SagaStatus(id uint64) (*Status, error) {
+	logID := sagaLogID(id)
+	store, err := e.logStorage()
+	if err != nil {
+		return nil, err
+	}
+	logs, err := store.Lookup(logID)
+	if err != nil {
+		return nil, &StorageError{Op: "Lookup", LogID: logID, Err: err}
+	}
+	if len(logs) == 0 {
+		return nil, errors.NotFoundf("saga %d", id)
+	}
+	state, err := rebuildState(logs)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return e.status(id, state), nil
+}
+
+func (e *ExecutionCoordinator) status(id uint64, state *sagaState) *Status {
+	status := &Status{
+		SagaID:   id,
+		SagaName: state.sagaName,
+		Start:    state.startTime,
+		End:      state.endTime,
+		Steps:    make([]StepStatus, 0, len(state.steps)),
+	}
+	def := e.sagaDefinitions[state.sagaName]
+	compensated := true
+	for _, step := range state.steps {
+		stepStatus := StepStatus{
+			StepID:             step.stepID,
+			SubTxID:            step.subTxID,
+			Kind:               step.kind,
+			State:              step.state(),
+			Params:             step.params,
+			Result:             step.result,
+			Attempts:           step.attempts,
+			Error:              step.actionError,
+			Start:              step.startTime,
+			End:                step.endTime,
+			CompensateAttempts: step.compensateAttempts,
+			CompensateError:    step.compensateError,
+			CompensateStart:    step.compensateTime,
+			CompensateEnd:      step.compensateEndTime,
+		}
+		if def != nil && step.stepID > 0 && step.stepID <= len(def.steps) {
+			stepStatus.Name = def.steps[step.stepID-1].Name
+		}
+		if stepStatus.State != StepCompensated && stepStatus.State != StepFailed {
+			compensated = false
+		}
+		status.Steps = append(status.Steps, stepStatus)
+	}
+
+	switch {
+	case state.ended && state.aborted:
+		status.State = StateCompensated
+	case state.ended:
+		status.State = StateCompleted
+	case state.stuckStep() != nil:
+		status.State = StateStuck
+	case state.aborted && compensated:
+		status.State = StateCompensated
+	case state.aborted:
+		status.State = StateAborting
+	default:
+		status.State = StateRunning
+	}
+	return status
+}
+
+func (s *stepState) state() StepState {
+	switch {
+	case s.stuck:
+		return StepStuck
+	case s.compensateEnded:
+		return StepCompensated
+	case s.compensateStarted:
+		return StepCompensating
+	case s.actionFailed:
+		return StepFailed
+	case s.actionEnded:
+		return StepCompleted
+	default:
+		return StepRunning
+	}
+}
@@ -0,0 +1,140 @@
+// Package bolt provides saga log storage base on embedded bbolt database.
+package bolt
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/lysu/go-saga/storage"
+	"go.etcd.io/bbolt"
+)
+
+func init() {
+	storage.Register("bolt", func(config interface{}) (storage.Storage, error) {
+		switch cfg := config.(type) {
+		case Config:
+			return New(cfg)
+		case *Config:
+			return New(*cfg)
+		default:
+			return nil, errors.NotValidf("bolt storage config %T", config)
+		}
+	})
+}
+
+// Config presents config of bolt storage.
+type Config struct {
+	// Path is database file path, it's created if not exists.
+	Path string
+	// Timeout is time to wait file lock held by other process, zero means wait forever.
+	Timeout time.Duration
+	// NoSync skips fsync after each commit, it's faster but logs may lost on crash.
+	NoSync bool
+}
+
+type boltStorage struct {
+	db *bbolt.DB
+}
+
+// New opens log storage base on bbolt.
+// Each logID is a bucket, and logs are keyed by big-endian sequence number of the bucket.
+func New(cfg Config) (storage.Storage, error) {
+	db, err := bbolt.Open(cfg.Path, 0600, &bbolt.Options{Timeout: cfg.Timeout, NoSync: cfg.NoSync})
+	if err != nil {
+		return nil, errors.Annotatef(err, "Open bolt database %s failure", cfg.Path)
+	}
+	return &boltStorage{db: db}, nil
+}
+
+// AppendLog appends log into bucket of logID with next sequence in a transaction.
+func (s *boltStorage) AppendLog(logID string, data string) error {
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(logID))
+		if err != nil {
+			return err
+		}
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		var key [8]byte
+		binary.BigEndian.PutUint64(key[:], seq)
+		return bucket.Put(key[:], []byte(data))
+	})
+	return errors.Annotatef(err, "Append log %s failure", logID)
+}
+
+// Lookup lookups logs under given logID in sequence order.
+func (s *boltStorage) Lookup(logID string) ([]string, error) {
+	data := []string{}
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(logID))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			data = append(data, string(v))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errors.Annotatef(err, "Lookup log %s failure", logID)
+	}
+	return data, nil
+}
+
+// Close closes database.
+func (s *boltStorage) Close() error {
+	return s.db.Close()
+}
+
+// LogIDs returns logIDs have logs.
+func (s *boltStorage) LogIDs() ([]string, error) {
+	ids := []string{}
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.ForEach(func(name []byte, _ *bbolt.Bucket) error {
+			ids = append(ids, string(name))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errors.Annotate(err, "List log IDs failure")
+	}
+	return ids, nil
+}
+
+// Cleanup deletes bucket of logID.
+func (s *boltStorage) Cleanup(logID string) error {
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		err := tx.DeleteBucket([]byte(logID))
+		if err == bbolt.ErrBucketNotFound {
+			return nil
+		}
+		return err
+	})
+	return errors.Annotatef(err, "Cleanup log %s failure", logID)
+}
+
+// LastLog returns last log under logID by cursor, NotFound error returns if no log.
+func (s *boltStorage) LastLog(logID string) (string, error) {
+	var data string
+	found := false
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(logID))
+		if bucket == nil {
+			return nil
+		}
+		if k, v := bucket.Cursor().Last(); k != nil {
+			data, found = string(v), true
+		}
+		return nil
+	})
+	if err != nil {
+		return "", errors.Annotatef(err, "Fetch last log %s failure", logID)
+	}
+	if !found {
+		return "", errors.NotFoundf("LogData %s", logID)
+	}
+	return data, nil
+}
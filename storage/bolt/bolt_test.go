@@ -0,0 +1,75 @@
+package bolt
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBoltStorage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "saga.db")
+	s, err := New(Config{Path: path})
+	assert.NoError(t, err)
+	err = s.AppendLog("t_11", "{}")
+	assert.NoError(t, err)
+	err = s.AppendLog("t_11", `{"type":2}`)
+	assert.NoError(t, err)
+	looked, err := s.Lookup("t_11")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"{}", `{"type":2}`}, looked)
+	last, err := s.LastLog("t_11")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"type":2}`, last)
+	assert.NoError(t, s.Close())
+
+	s, err = New(Config{Path: path})
+	assert.NoError(t, err)
+	ids, err := s.LogIDs()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"t_11"}, ids)
+	assert.NoError(t, s.Cleanup("t_11"))
+	looked, err = s.Lookup("t_11")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(looked))
+	_, err = s.LastLog("t_11")
+	assert.True(t, errors.IsNotFound(err))
+	assert.NoError(t, s.Close())
+}
+
+func TestBoltStorageConcurrent(t *testing.T) {
+	s, err := New(Config{Path: filepath.Join(t.TempDir(), "saga.db")})
+	assert.NoError(t, err)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			logID := fmt.Sprintf("t_%d", i%2)
+			assert.NoError(t, s.AppendLog(logID, fmt.Sprintf(`{"seq":%d}`, i)))
+			_, err := s.Lookup(logID)
+			assert.NoError(t, err)
+			_, err = s.LogIDs()
+			assert.NoError(t, err)
+			_, err = s.LastLog(logID)
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+
+	looked, err := s.Lookup("t_0")
+	assert.NoError(t, err)
+	assert.Equal(t, 5, len(looked))
+	looked[0] = "changed"
+	looked, err = s.Lookup("t_0")
+	assert.NoError(t, err)
+	assert.NotEqual(t, "changed", looked[0])
+
+	ids, err := s.LogIDs()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"t_0", "t_1"}, ids)
+	assert.NoError(t, s.Close())
+}
@@ -0,0 +1,113 @@
+package file
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/juju/errors"
+)
+
+// deadRatio returns ratio of dead bytes in sealed segments,
+// it's zero until sealed segments grow larger than a segment.
+func (s *Storage) deadRatio() float64 {
+	var size, live int64
+	for _, seg := range s.segments[:len(s.segments)-1] {
+		size += seg.size
+		live += seg.live
+	}
+	if size == 0 || size < s.cfg.SegmentSize {
+		return 0
+	}
+	return float64(size-live) / float64(size)
+}
+
+// Compact rewrites all sealed segments into one segment with only live records, active segment is not touched.
+// It's called automatically after Cleanup by Config.CompactRatio.
+func (s *Storage) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.compact()
+}
+
+// compact writes live records of sealed segments into a temp file begins with compacted marker,
+// then renames it to replace the newest sealed segment and removes older ones.
+// Crash before rename leaves segments untouched, and older segments left after rename are removed on open.
+func (s *Storage) compact() error {
+	sealed := s.segments[:len(s.segments)-1]
+	if len(sealed) == 0 {
+		return nil
+	}
+	target := sealed[len(sealed)-1].id
+	path := filepath.Join(s.cfg.Dir, segmentName(target))
+	f, err := os.OpenFile(path+".compact", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return errors.Annotate(err, "Create compaction file failure")
+	}
+	seg, index, err := s.rewrite(f, target)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return errors.Annotate(err, "Compact segments failure")
+	}
+	if err := s.syncDir(); err != nil {
+		return err
+	}
+
+	// compacted segment takes effect after rename, old segments left by removal failure are removed on open.
+	s.segments = append([]*segment{seg}, s.active())
+	s.index = index
+	for _, old := range sealed {
+		_ = old.file.Close()
+		if old.id != target {
+			if err := os.Remove(filepath.Join(s.cfg.Dir, segmentName(old.id))); err != nil {
+				return errors.Annotatef(err, "Remove compacted segment %d failure", old.id)
+			}
+		}
+	}
+	return nil
+}
+
+// rewrite writes marker and live records in sealed segments(id <= target) into f,
+// returns new segment and index pointing to it.
+func (s *Storage) rewrite(f *os.File, target uint64) (*segment, map[string][]position, error) {
+	marker := record{op: opCompacted}.encode()
+	if _, err := f.WriteAt(marker, 0); err != nil {
+		return nil, nil, err
+	}
+	seg := &segment{id: target, file: f, size: int64(len(marker))}
+	logIDs := make([]string, 0, len(s.index))
+	for logID := range s.index {
+		logIDs = append(logIDs, logID)
+	}
+	sort.Strings(logIDs)
+	index := make(map[string][]position, len(s.index))
+	for _, logID := range logIDs {
+		positions := make([]position, 0, len(s.index[logID]))
+		for _, pos := range s.index[logID] {
+			if pos.segment > target {
+				positions = append(positions, pos)
+				continue
+			}
+			rec, err := s.read(pos)
+			if err != nil {
+				return nil, nil, err
+			}
+			data := rec.encode()
+			if _, err := f.WriteAt(data, seg.size); err != nil {
+				return nil, nil, err
+			}
+			positions = append(positions, position{segment: target, offset: seg.size, size: len(data)})
+			seg.size += int64(len(data))
+			seg.live += int64(len(data))
+		}
+		index[logID] = positions
+	}
+	return seg, index, nil
+}
@@ -0,0 +1,443 @@
+// Package file provides durable saga log storage base on segmented write-ahead log files.
+package file
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/lysu/go-saga/storage"
+)
+
+func init() {
+	storage.Register("file", func(config interface{}) (storage.Storage, error) {
+		switch cfg := config.(type) {
+		case Config:
+			return New(cfg)
+		case *Config:
+			return New(*cfg)
+		default:
+			return nil, errors.NotValidf("file storage config %T", config)
+		}
+	})
+}
+
+// SyncPolicy presents when appended records are fsynced to disk.
+type SyncPolicy int
+
+const (
+	// SyncAlways fsyncs every append before return, it's the default
+	SyncAlways SyncPolicy = iota
+	// SyncInterval fsyncs in background every Config.SyncInterval, records in interval may lost on crash
+	SyncInterval
+	// SyncNever leaves fsync to operating system
+	SyncNever
+)
+
+const (
+	// DefaultSegmentSize is default size a segment rolls over at.
+	DefaultSegmentSize = 64 << 20
+	// DefaultSyncInterval is default interval of SyncInterval.
+	DefaultSyncInterval = time.Second
+	// DefaultCompactRatio is default dead ratio of sealed segments to trigger compaction.
+	DefaultCompactRatio = 0.5
+
+	segmentExt = ".wal"
+)
+
+// Config presents config of file storage.
+type Config struct {
+	// Dir is directory of segment files, it's created if not exists.
+	Dir string
+	// SegmentSize is size a segment rolls over at, default is DefaultSegmentSize.
+	SegmentSize int64
+	// Sync is fsync policy.
+	Sync SyncPolicy
+	// SyncInterval is fsync interval for SyncInterval policy, default is DefaultSyncInterval.
+	SyncInterval time.Duration
+	// CompactRatio triggers compaction after Cleanup when dead bytes in sealed segments exceeds it,
+	// default is DefaultCompactRatio, negative disables automatic compaction.
+	CompactRatio float64
+}
+
+// position locates a record in segments.
+type position struct {
+	segment uint64
+	offset  int64
+	size    int
+}
+
+type segment struct {
+	id   uint64
+	file *os.File
+	size int64
+	// live is bytes of records still referenced by index.
+	live int64
+}
+
+// Storage is saga log storage base on segmented write-ahead log files.
+//
+// Every AppendLog and Cleanup appends a length-prefixed and checksummed record to active segment,
+// index from logID to record positions is rebuilt by scanning segments on open,
+// and torn record at tail of last segment left by crash is truncated.
+type Storage struct {
+	cfg      Config
+	mu       sync.Mutex
+	segments []*segment
+	index    map[string][]position
+	dirty    bool
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// New opens file storage in cfg.Dir, existing segments are recovered.
+func New(cfg Config) (*Storage, error) {
+	if cfg.Dir == "" {
+		return nil, errors.NotValidf("empty dir")
+	}
+	if cfg.SegmentSize <= 0 {
+		cfg.SegmentSize = DefaultSegmentSize
+	}
+	if cfg.SyncInterval <= 0 {
+		cfg.SyncInterval = DefaultSyncInterval
+	}
+	if cfg.CompactRatio == 0 {
+		cfg.CompactRatio = DefaultCompactRatio
+	}
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, errors.Annotatef(err, "Create dir %s failure", cfg.Dir)
+	}
+	s := &Storage{cfg: cfg, index: make(map[string][]position)}
+	if err := s.recover(); err != nil {
+		s.closeSegments()
+		return nil, err
+	}
+	if cfg.Sync == SyncInterval {
+		s.stop, s.done = make(chan struct{}), make(chan struct{})
+		go s.syncLoop()
+	}
+	return s, nil
+}
+
+func segmentName(id uint64) string {
+	return fmt.Sprintf("%016d%s", id, segmentExt)
+}
+
+// segmentIDs returns ids of segment files in dir in order.
+func segmentIDs(dir string) ([]uint64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Annotatef(err, "Read dir %s failure", dir)
+	}
+	ids := []uint64{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+// recover opens segments and rebuilds index.
+// Segments covered by a compacted segment are left by crash during compaction, they are removed.
+func (s *Storage) recover() error {
+	ids, err := segmentIDs(s.cfg.Dir)
+	if err != nil {
+		return err
+	}
+	for i := len(ids) - 1; i > 0; i-- {
+		compacted, err := s.isCompacted(ids[i])
+		if err != nil {
+			return err
+		}
+		if !compacted {
+			continue
+		}
+		for _, id := range ids[:i] {
+			if err := os.Remove(filepath.Join(s.cfg.Dir, segmentName(id))); err != nil {
+				return errors.Annotatef(err, "Remove compacted segment %d failure", id)
+			}
+		}
+		ids = ids[i:]
+		break
+	}
+	if len(ids) == 0 {
+		ids = append(ids, 1)
+	}
+	for i, id := range ids {
+		f, err := os.OpenFile(filepath.Join(s.cfg.Dir, segmentName(id)), os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return errors.Annotatef(err, "Open segment %d failure", id)
+		}
+		seg := &segment{id: id, file: f}
+		s.segments = append(s.segments, seg)
+		if err := s.scan(seg, i == len(ids)-1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Storage) isCompacted(id uint64) (bool, error) {
+	f, err := os.Open(filepath.Join(s.cfg.Dir, segmentName(id)))
+	if err != nil {
+		return false, errors.Annotatef(err, "Open segment %d failure", id)
+	}
+	defer f.Close()
+	rec, _, err := readRecord(f, 0)
+	if err == io.EOF || err == errTornRecord {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Annotatef(err, "Read segment %d failure", id)
+	}
+	return rec.op == opCompacted, nil
+}
+
+// scan replays records of segment into index, torn tail of last segment is truncated.
+func (s *Storage) scan(seg *segment, last bool) error {
+	var offset int64
+	for {
+		rec, size, err := readRecord(seg.file, offset)
+		if err == io.EOF {
+			break
+		}
+		if err == errTornRecord {
+			if !last {
+				return errors.Errorf("segment %d corrupted at offset %d", seg.id, offset)
+			}
+			if err := seg.file.Truncate(offset); err != nil {
+				return errors.Annotatef(err, "Truncate torn record of segment %d failure", seg.id)
+			}
+			if err := seg.file.Sync(); err != nil {
+				return errors.Trace(err)
+			}
+			break
+		}
+		if err != nil {
+			return errors.Annotatef(err, "Read segment %d at offset %d failure", seg.id, offset)
+		}
+		s.apply(rec, position{segment: seg.id, offset: offset, size: size})
+		offset += int64(size)
+	}
+	seg.size = offset
+	return nil
+}
+
+// apply applies record at pos into index.
+func (s *Storage) apply(rec record, pos position) {
+	switch rec.op {
+	case opAppend:
+		s.index[rec.logID] = append(s.index[rec.logID], pos)
+		s.segment(pos.segment).live += int64(pos.size)
+	case opCleanup:
+		for _, p := range s.index[rec.logID] {
+			s.segment(p.segment).live -= int64(p.size)
+		}
+		delete(s.index, rec.logID)
+	}
+}
+
+func (s *Storage) segment(id uint64) *segment {
+	i := sort.Search(len(s.segments), func(i int) bool { return s.segments[i].id >= id })
+	return s.segments[i]
+}
+
+func (s *Storage) active() *segment {
+	return s.segments[len(s.segments)-1]
+}
+
+// write appends record to active segment and syncs it by policy, active segment rolls over when full.
+func (s *Storage) write(rec record) (position, error) {
+	if len(rec.logID) > maxLogIDLen {
+		return position{}, errors.NotValidf("logID longer than %d", maxLogIDLen)
+	}
+	seg := s.active()
+	if seg.size >= s.cfg.SegmentSize {
+		if err := s.roll(); err != nil {
+			return position{}, err
+		}
+		seg = s.active()
+	}
+	data := rec.encode()
+	if _, err := seg.file.WriteAt(data, seg.size); err != nil {
+		return position{}, errors.Annotatef(err, "Write segment %d failure", seg.id)
+	}
+	pos := position{segment: seg.id, offset: seg.size, size: len(data)}
+	seg.size += int64(len(data))
+	switch s.cfg.Sync {
+	case SyncAlways:
+		if err := seg.file.Sync(); err != nil {
+			return position{}, errors.Annotatef(err, "Sync segment %d failure", seg.id)
+		}
+	case SyncInterval:
+		s.dirty = true
+	}
+	return pos, nil
+}
+
+// roll seals active segment and creates a new one.
+func (s *Storage) roll() error {
+	seg := s.active()
+	if err := seg.file.Sync(); err != nil {
+		return errors.Annotatef(err, "Sync segment %d failure", seg.id)
+	}
+	id := seg.id + 1
+	f, err := os.OpenFile(filepath.Join(s.cfg.Dir, segmentName(id)), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return errors.Annotatef(err, "Create segment %d failure", id)
+	}
+	s.segments = append(s.segments, &segment{id: id, file: f})
+	return s.syncDir()
+}
+
+func (s *Storage) syncDir() error {
+	dir, err := os.Open(s.cfg.Dir)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer dir.Close()
+	return errors.Trace(dir.Sync())
+}
+
+func (s *Storage) syncLoop() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.cfg.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			if s.dirty {
+				if err := s.active().file.Sync(); err == nil {
+					s.dirty = false
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// AppendLog appends log under given logID.
+func (s *Storage) AppendLog(logID string, data string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec := record{op: opAppend, logID: logID, data: data}
+	pos, err := s.write(rec)
+	if err != nil {
+		return err
+	}
+	s.apply(rec, pos)
+	return nil
+}
+
+// Lookup lookups logs under given logID in append order.
+func (s *Storage) Lookup(logID string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data := make([]string, 0, len(s.index[logID]))
+	for _, pos := range s.index[logID] {
+		rec, err := s.read(pos)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, rec.data)
+	}
+	return data, nil
+}
+
+func (s *Storage) read(pos position) (record, error) {
+	rec, _, err := readRecord(s.segment(pos.segment).file, pos.offset)
+	if err != nil {
+		return record{}, errors.Annotatef(err, "Read segment %d at offset %d failure", pos.segment, pos.offset)
+	}
+	return rec, nil
+}
+
+// LogIDs returns logIDs have logs.
+func (s *Storage) LogIDs() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make([]string, 0, len(s.index))
+	for id := range s.index {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// Cleanup deletes logs under logID by appending a tombstone record,
+// space is reclaimed by compaction.
+func (s *Storage) Cleanup(logID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.index[logID]; !ok {
+		return nil
+	}
+	rec := record{op: opCleanup, logID: logID}
+	pos, err := s.write(rec)
+	if err != nil {
+		return err
+	}
+	s.apply(rec, pos)
+	if s.cfg.CompactRatio > 0 && s.deadRatio() >= s.cfg.CompactRatio {
+		return s.compact()
+	}
+	return nil
+}
+
+// LastLog returns last log under logID, NotFound error returns if no log.
+func (s *Storage) LastLog(logID string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	positions := s.index[logID]
+	if len(positions) == 0 {
+		return "", errors.NotFoundf("LogData %s", logID)
+	}
+	rec, err := s.read(positions[len(positions)-1])
+	if err != nil {
+		return "", err
+	}
+	return rec.data, nil
+}
+
+// Close syncs and closes segments.
+func (s *Storage) Close() error {
+	if s.stop != nil {
+		close(s.stop)
+		<-s.done
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.active().file.Sync(); err != nil {
+		return errors.Trace(err)
+	}
+	return s.closeSegments()
+}
+
+func (s *Storage) closeSegments() error {
+	var firstErr error
+	for _, seg := range s.segments {
+		if err := seg.file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	s.segments = nil
+	return errors.Trace(firstErr)
+}
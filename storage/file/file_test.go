@@ -0,0 +1,118 @@
+package file
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileStorage(t *testing.T) {
+	dir := t.TempDir()
+	s, err := New(Config{Dir: dir})
+	assert.NoError(t, err)
+
+	_, err = s.LastLog("saga_1")
+	assert.True(t, errors.IsNotFound(err))
+	assert.NoError(t, s.AppendLog("saga_1", `{"type":1}`))
+	assert.NoError(t, s.AppendLog("saga_2", `{"type":1}`))
+	assert.NoError(t, s.AppendLog("saga_1", `{"type":4}`))
+	assert.NoError(t, s.AppendLog("saga_3", `{"type":1}`))
+	assert.NoError(t, s.Cleanup("saga_3"))
+	assert.NoError(t, s.Close())
+
+	// torn write at tail by crash
+	f, err := os.OpenFile(filepath.Join(dir, segmentName(1)), os.O_WRONLY|os.O_APPEND, 0644)
+	assert.NoError(t, err)
+	torn := record{op: opAppend, logID: "saga_1", data: `{"type":5}`}.encode()
+	_, err = f.Write(torn[:len(torn)-3])
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	s, err = New(Config{Dir: dir, Sync: SyncInterval})
+	assert.NoError(t, err)
+	looked, err := s.Lookup("saga_1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`{"type":1}`, `{"type":4}`}, looked)
+	last, err := s.LastLog("saga_1")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"type":4}`, last)
+	ids, err := s.LogIDs()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"saga_1", "saga_2"}, ids)
+
+	assert.NoError(t, s.AppendLog("saga_1", `{"type":2}`))
+	looked, err = s.Lookup("saga_1")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(looked))
+	assert.NoError(t, s.Close())
+}
+
+func TestCompact(t *testing.T) {
+	dir := t.TempDir()
+	s, err := New(Config{Dir: dir, SegmentSize: 256, Sync: SyncNever})
+	assert.NoError(t, err)
+	for i := 0; i < 40; i++ {
+		assert.NoError(t, s.AppendLog(fmt.Sprintf("saga_%d", i%4), fmt.Sprintf(`{"stepID":%d}`, i)))
+	}
+	assert.True(t, len(s.segments) > 3)
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, s.Cleanup(fmt.Sprintf("saga_%d", i)))
+	}
+	assert.Equal(t, 2, len(s.segments))
+	ids, err := segmentIDs(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(ids))
+
+	expected, err := s.Lookup("saga_3")
+	assert.NoError(t, err)
+	assert.Equal(t, 10, len(expected))
+	assert.NoError(t, s.Close())
+
+	// crash after compacted segment renamed but before older segments removed
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, segmentName(0)),
+		record{op: opAppend, logID: "saga_0", data: "{}"}.encode(), 0644))
+	s, err = New(Config{Dir: dir, SegmentSize: 256})
+	assert.NoError(t, err)
+	looked, err := s.Lookup("saga_3")
+	assert.NoError(t, err)
+	assert.Equal(t, expected, looked)
+	logIDs, err := s.LogIDs()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"saga_3"}, logIDs)
+	assert.NoError(t, s.Close())
+}
+
+// failReader fails reads beyond limit with err.
+type failReader struct {
+	data  []byte
+	limit int64
+	err   error
+}
+
+func (r failReader) ReadAt(p []byte, off int64) (int, error) {
+	if off+int64(len(p)) > r.limit {
+		return 0, r.err
+	}
+	return copy(p, r.data[off:]), nil
+}
+
+func TestReadRecordFailure(t *testing.T) {
+	data := record{op: opAppend, logID: "saga_1", data: "{}"}.encode()
+
+	_, _, err := readRecord(failReader{data: data, limit: int64(len(data)), err: syscall.EIO}, 0)
+	assert.NoError(t, err)
+	_, _, err = readRecord(failReader{data: data, limit: headerSize, err: syscall.EIO}, 0)
+	assert.NotEqual(t, errTornRecord, err)
+	assert.True(t, errors.Cause(err) == syscall.EIO)
+	_, _, err = readRecord(failReader{data: data, limit: headerSize, err: io.ErrUnexpectedEOF}, 0)
+	assert.Equal(t, errTornRecord, err)
+	_, _, err = readRecord(bytes.NewReader(data[:len(data)-1]), 0)
+	assert.Equal(t, errTornRecord, err)
+}
@@ -0,0 +1,94 @@
+package file
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+
+	"github.com/juju/errors"
+)
+
+// Record layout: length(4 bytes) | crc32c of payload(4 bytes) | payload.
+// Payload layout: op(1 byte) | length of logID(2 bytes) | logID | data.
+const (
+	headerSize  = 8
+	maxLogIDLen = 1<<16 - 1
+	// maxRecordSize guards against reading garbage length of torn record.
+	maxRecordSize = 1 << 30
+)
+
+type recordOp byte
+
+const (
+	// opAppend flag a saga log entry
+	opAppend recordOp = iota + 1
+	// opCleanup flag all entries of logID before are deleted
+	opCleanup
+	// opCompacted flag segment is compaction result of all segments before it
+	opCompacted
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+var errTornRecord = errors.New("torn or corrupted record")
+
+type record struct {
+	op    recordOp
+	logID string
+	data  string
+}
+
+func (r record) encode() []byte {
+	payloadSize := 1 + 2 + len(r.logID) + len(r.data)
+	buf := make([]byte, headerSize+payloadSize)
+	payload := buf[headerSize:]
+	payload[0] = byte(r.op)
+	binary.BigEndian.PutUint16(payload[1:], uint16(len(r.logID)))
+	copy(payload[3:], r.logID)
+	copy(payload[3+len(r.logID):], r.data)
+	binary.BigEndian.PutUint32(buf, uint32(payloadSize))
+	binary.BigEndian.PutUint32(buf[4:], crc32.Checksum(payload, crcTable))
+	return buf
+}
+
+// readRecord reads record at offset, it returns record and it's size.
+// io.EOF returns if offset is end of reader, errTornRecord returns if record is incomplete or checksum mismatched.
+// Other read errors are returned as is, they don't mean record is torn.
+func readRecord(r io.ReaderAt, offset int64) (record, int, error) {
+	var header [headerSize]byte
+	n, err := r.ReadAt(header[:], offset)
+	if n == 0 && err == io.EOF {
+		return record{}, 0, io.EOF
+	}
+	if n < headerSize {
+		return record{}, 0, readFailure(err)
+	}
+	payloadSize := binary.BigEndian.Uint32(header[:])
+	if payloadSize < 3 || payloadSize > maxRecordSize {
+		return record{}, 0, errTornRecord
+	}
+	payload := make([]byte, payloadSize)
+	if n, err := r.ReadAt(payload, offset+headerSize); n < len(payload) {
+		return record{}, 0, readFailure(err)
+	}
+	if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(header[4:]) {
+		return record{}, 0, errTornRecord
+	}
+	idLen := int(binary.BigEndian.Uint16(payload[1:]))
+	if 3+idLen > len(payload) {
+		return record{}, 0, errTornRecord
+	}
+	return record{
+		op:    recordOp(payload[0]),
+		logID: string(payload[3 : 3+idLen]),
+		data:  string(payload[3+idLen:]),
+	}, headerSize + int(payloadSize), nil
+}
+
+// readFailure maps error of short read, it's errTornRecord if reader ends before record complete.
+func readFailure(err error) error {
+	if err == nil || err == io.EOF || err == io.ErrUnexpectedEOF {
+		return errTornRecord
+	}
+	return errors.Trace(err)
+}
@@ -7,27 +7,34 @@ import (
 	"github.com/lysu/go-saga/storage"
 	"github.com/lysu/kazoo-go"
 	"strings"
-	"sync"
 	"time"
 )
 
-var storageInstance storage.Storage
-var kafkaInit sync.Once
-
 func init() {
-	saga.StorageProvider = func(cfg storage.StorageConfig) storage.Storage {
-		kafkaInit.Do(func() {
-			var err error
-			storageInstance, err = newKafkaStorage(
-				cfg.Kafka.ZkAddrs, cfg.Kafka.BrokerAddrs, cfg.Kafka.Partitions,
-				cfg.Kafka.Replicas, cfg.Kafka.ReturnDuration,
-			)
-			if err != nil {
-				panic(err)
-			}
-		})
-		return storageInstance
-	}
+	storage.Register("kafka", func(config interface{}) (storage.Storage, error) {
+		switch cfg := config.(type) {
+		case Config:
+			return New(cfg)
+		case *Config:
+			return New(*cfg)
+		default:
+			return nil, errors.NotValidf("kafka storage config %T", config)
+		}
+	})
+}
+
+// Config presents config of Kafka storage.
+//
+// By default each saga log uses its own topic, which is created and deleted through Zookeeper.
+// If Topics is set, storage runs in shared mode: all saga logs are written into these compacted topics,
+// one message per log entry keyed by `<logID>/<entry ID>`, and Partitions and Replicas are used to create them when not exist.
+type Config struct {
+	ZkAddrs, BrokerAddrs []string
+	Partitions, Replicas int
+	ReturnDuration       time.Duration
+
+	Topics      []string
+	SyncTimeout time.Duration
 }
 
 type kafkaStorage struct {
@@ -39,28 +46,31 @@ type kafkaStorage struct {
 	consumeReturnDuration time.Duration
 }
 
-// NewKafkaStorage creates log storage base on Kafka.
-func newKafkaStorage(zkAddrs, brokerAddrs []string, partitions, replicas int, returnDuration time.Duration) (storage.Storage, error) {
+// New creates log storage base on Kafka.
+func New(cfg Config) (storage.Storage, error) {
+	if len(cfg.Topics) > 0 {
+		return newShared(cfg)
+	}
 	conf := kazoo.NewConfig()
-	kz, err := kazoo.NewKazoo(zkAddrs, conf)
+	kz, err := kazoo.NewKazoo(cfg.ZkAddrs, conf)
 	if err != nil {
 		return nil, errors.Annotate(err, "Start Zookeeper client failure")
 	}
-	producer, err := sarama.NewSyncProducer(brokerAddrs, nil)
+	producer, err := sarama.NewSyncProducer(cfg.BrokerAddrs, nil)
 	if err != nil {
-		return nil, errors.Annotatef(err, "Start Kafka Storage failure: %v", brokerAddrs)
+		return nil, errors.Annotatef(err, "Start Kafka Storage failure: %v", cfg.BrokerAddrs)
 	}
-	consumer, err := sarama.NewConsumer(brokerAddrs, nil)
+	consumer, err := sarama.NewConsumer(cfg.BrokerAddrs, nil)
 	if err != nil {
-		return nil, errors.Annotatef(err, "Create Consumer failure: %v", brokerAddrs)
+		return nil, errors.Annotatef(err, "Create Consumer failure: %v", cfg.BrokerAddrs)
 	}
 	return &kafkaStorage{
 		producer:              producer,
 		consumer:              consumer,
 		kz:                    kz,
-		partitionNumbers:      partitions,
-		replicaNumbers:        replicas,
-		consumeReturnDuration: returnDuration,
+		partitionNumbers:      cfg.Partitions,
+		replicaNumbers:        cfg.Replicas,
+		consumeReturnDuration: cfg.ReturnDuration,
 	}, nil
 }
 
@@ -81,7 +91,7 @@ func (s *kafkaStorage) AppendLog(logID string, data string) error {
 	if err != nil {
 		return errors.Annotatef(err, " failure send %s", data)
 	}
-	saga.Logger.Printf("> message sent to partition %d at offset %d\n", partition, offset)
+	saga.GetLogger().Debug("saga log sent", "logID", logID, "partition", partition, "offset", offset)
 	return nil
 }
 
@@ -94,7 +104,7 @@ func (s *kafkaStorage) Lookup(logID string) ([]string, error) {
 
 	defer func() {
 		if err := partitionConsumer.Close(); err != nil {
-			saga.Logger.Printf("[WARNING]Close consumer failure %v", err)
+			saga.GetLogger().Warn("close consumer failure", "logID", logID, "error", err)
 		}
 	}()
 
@@ -106,7 +116,7 @@ consumer_loop:
 	for {
 		select {
 		case msg := <-partitionConsumer.Messages():
-			saga.Logger.Printf("Consumed message offset %d\n", msg.Offset)
+			saga.GetLogger().Debug("saga log consumed", "logID", logID, "offset", msg.Offset)
 			consumed++
 			msgValue := string(msg.Value)
 			data = append(data, msgValue)
@@ -116,7 +126,7 @@ consumer_loop:
 		}
 	}
 
-	saga.Logger.Printf("Consumed: %d\n", consumed)
+	saga.GetLogger().Debug("saga log lookup", "logID", logID, "consumed", consumed)
 	return data, nil
 }
 
@@ -0,0 +1,376 @@
+package kafka
+
+import (
+	crand "crypto/rand"
+	"encoding/hex"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+	"github.com/lysu/go-saga"
+	"github.com/lysu/go-saga/storage"
+	"github.com/lysu/kazoo-go"
+)
+
+const defaultSyncTimeout = 10 * time.Second
+
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+// entry presents a log entry in index, key is it's message key.
+type entry struct {
+	key  string
+	data string
+}
+
+// sharedStorage keeps all saga logs in a few compacted topics.
+//
+// Each log entry is a message keyed by `<logID>/<entry ID>`, entry ID is unique among writers,
+// so compaction keeps every entry and concurrent writers never overwrite each other.
+// All entries of a logID go to same partition, their order is the partition order.
+// Cleanup writes tombstone for every entry of logID.
+// Lookup and LastLog are served from index materialized by consuming all partitions.
+type sharedStorage struct {
+	topics      []string
+	partitions  map[string][]int32
+	syncTimeout time.Duration
+	writerID    string
+	entrySeq    uint64
+
+	client    sarama.Client
+	producer  sarama.SyncProducer
+	consumer  sarama.Consumer
+	consumers []sarama.PartitionConsumer
+	wg        sync.WaitGroup
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	logs    map[string][]entry
+	applied map[topicPartition]int64
+	closed  bool
+}
+
+func newShared(cfg Config) (storage.Storage, error) {
+	if len(cfg.ZkAddrs) > 0 {
+		if err := createCompactedTopics(cfg); err != nil {
+			return nil, err
+		}
+	}
+	conf := sarama.NewConfig()
+	conf.Producer.Return.Successes = true
+	conf.Producer.RequiredAcks = sarama.WaitForAll
+	conf.Producer.Partitioner = sarama.NewManualPartitioner
+	client, err := sarama.NewClient(cfg.BrokerAddrs, conf)
+	if err != nil {
+		return nil, errors.Annotatef(err, "Create Kafka client failure: %v", cfg.BrokerAddrs)
+	}
+	s := newSharedStorage(cfg.Topics, cfg.SyncTimeout)
+	s.client = client
+	if err := s.start(); err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func newSharedStorage(topics []string, syncTimeout time.Duration) *sharedStorage {
+	s := &sharedStorage{
+		topics:      topics,
+		partitions:  make(map[string][]int32, len(topics)),
+		syncTimeout: syncTimeout,
+		writerID:    newWriterID(),
+		logs:        make(map[string][]entry),
+		applied:     make(map[topicPartition]int64),
+	}
+	s.cond = sync.NewCond(&s.mu)
+	if s.syncTimeout <= 0 {
+		s.syncTimeout = defaultSyncTimeout
+	}
+	return s
+}
+
+// newWriterID returns random ID of storage instance, it makes entry IDs unique among writers.
+func newWriterID() string {
+	var id [8]byte
+	_, _ = crand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
+
+func createCompactedTopics(cfg Config) error {
+	kz, err := kazoo.NewKazoo(cfg.ZkAddrs, kazoo.NewConfig())
+	if err != nil {
+		return errors.Annotate(err, "Start Zookeeper client failure")
+	}
+	defer func() {
+		_ = kz.Close()
+	}()
+	for _, topic := range cfg.Topics {
+		exists, err := kz.ExistsTopic(topic)
+		if err != nil {
+			return errors.Annotatef(err, "for %s", topic)
+		}
+		if exists {
+			continue
+		}
+		err = kz.CreateTopic(topic, cfg.Partitions, cfg.Replicas, map[string]interface{}{"cleanup.policy": "compact"})
+		if err != nil {
+			return errors.Annotatef(err, "for topic %s", topic)
+		}
+	}
+	return nil
+}
+
+// start consumes all partitions from oldest offset and waits index catch up with current high water mark.
+func (s *sharedStorage) start() error {
+	producer, err := sarama.NewSyncProducerFromClient(s.client)
+	if err != nil {
+		return errors.Annotate(err, "Start Kafka Storage failure")
+	}
+	s.producer = producer
+	consumer, err := sarama.NewConsumerFromClient(s.client)
+	if err != nil {
+		return errors.Annotate(err, "Create Consumer failure")
+	}
+	s.consumer = consumer
+
+	highWaterMarks := make(map[topicPartition]int64)
+	for _, topic := range s.topics {
+		partitions, err := s.client.Partitions(topic)
+		if err != nil {
+			return errors.Annotatef(err, "Get partitions of topic %s failure", topic)
+		}
+		if len(partitions) == 0 {
+			return errors.NotFoundf("partitions of topic %s", topic)
+		}
+		sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+		s.partitions[topic] = partitions
+		for _, partition := range partitions {
+			tp := topicPartition{topic: topic, partition: partition}
+			oldest, err := s.client.GetOffset(topic, partition, sarama.OffsetOldest)
+			if err != nil {
+				return errors.Annotatef(err, "Get oldest offset of %s/%d failure", topic, partition)
+			}
+			newest, err := s.client.GetOffset(topic, partition, sarama.OffsetNewest)
+			if err != nil {
+				return errors.Annotatef(err, "Get newest offset of %s/%d failure", topic, partition)
+			}
+			highWaterMarks[tp] = newest
+			s.mu.Lock()
+			s.applied[tp] = oldest
+			s.mu.Unlock()
+
+			pc, err := consumer.ConsumePartition(topic, partition, oldest)
+			if err != nil {
+				return errors.Annotatef(err, "Consume %s/%d failure", topic, partition)
+			}
+			s.consumers = append(s.consumers, pc)
+			s.wg.Add(1)
+			go s.consume(pc)
+		}
+	}
+	for tp, offset := range highWaterMarks {
+		if err := s.waitApplied(tp, offset); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sharedStorage) consume(pc sarama.PartitionConsumer) {
+	defer s.wg.Done()
+	for msg := range pc.Messages() {
+		s.apply(msg)
+	}
+}
+
+// apply applies consumed message into index, nil value is tombstone of the entry.
+func (s *sharedStorage) apply(msg *sarama.ConsumerMessage) {
+	key := string(msg.Key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.cond.Broadcast()
+	s.applied[topicPartition{topic: msg.Topic, partition: msg.Partition}] = msg.Offset + 1
+	i := strings.LastIndex(key, "/")
+	if i < 0 {
+		saga.GetLogger().Warn("skip saga log message without entry ID", "key", key,
+			"partition", msg.Partition, "offset", msg.Offset)
+		return
+	}
+	logID := key[:i]
+	if msg.Value != nil {
+		s.logs[logID] = append(s.logs[logID], entry{key: key, data: string(msg.Value)})
+		return
+	}
+	entries := s.logs[logID]
+	for j, e := range entries {
+		if e.key == key {
+			entries = append(entries[:j:j], entries[j+1:]...)
+			break
+		}
+	}
+	if len(entries) == 0 {
+		delete(s.logs, logID)
+		return
+	}
+	s.logs[logID] = entries
+}
+
+// waitApplied waits until index has applied all message before offset in given partition.
+func (s *sharedStorage) waitApplied(tp topicPartition, offset int64) error {
+	timeout := false
+	timer := time.AfterFunc(s.syncTimeout, func() {
+		s.mu.Lock()
+		timeout = true
+		s.cond.Broadcast()
+		s.mu.Unlock()
+	})
+	defer timer.Stop()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.applied[tp] < offset {
+		if s.closed {
+			return errors.New("kafka storage has been closed")
+		}
+		if timeout {
+			return errors.Timeoutf("consume %s/%d to offset %d", tp.topic, tp.partition, offset)
+		}
+		s.cond.Wait()
+	}
+	return nil
+}
+
+// route returns topic and partition for logID, all log of one saga always goes to same partition.
+func (s *sharedStorage) route(logID string) topicPartition {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(logID))
+	sum := h.Sum32()
+	topic := s.topics[sum%uint32(len(s.topics))]
+	partitions := s.partitions[topic]
+	partition := partitions[(sum/uint32(len(s.topics)))%uint32(len(partitions))]
+	return topicPartition{topic: topic, partition: partition}
+}
+
+// send produces message with key into partition of logID, and returns offset of it.
+func (s *sharedStorage) send(logID, key string, value sarama.Encoder) (topicPartition, int64, error) {
+	tp := s.route(logID)
+	msg := &sarama.ProducerMessage{
+		Topic:     tp.topic,
+		Partition: tp.partition,
+		Key:       sarama.StringEncoder(key),
+		Value:     value,
+	}
+	partition, offset, err := s.producer.SendMessage(msg)
+	if err != nil {
+		return tp, 0, errors.Annotatef(err, "failure send log %s", logID)
+	}
+	saga.GetLogger().Debug("saga log sent", "logID", logID, "key", key, "partition", partition, "offset", offset)
+	tp.partition = partition
+	return tp, offset, nil
+}
+
+// AppendLog writes data as a new entry of logID, and waits it applied into index.
+func (s *sharedStorage) AppendLog(logID string, data string) error {
+	key := logID + "/" + s.writerID + "." + strconv.FormatUint(atomic.AddUint64(&s.entrySeq, 1), 36)
+	tp, offset, err := s.send(logID, key, sarama.StringEncoder(data))
+	if err != nil {
+		return err
+	}
+	return s.waitApplied(tp, offset+1)
+}
+
+// Lookup returns log under logID from local index.
+func (s *sharedStorage) Lookup(logID string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries, ok := s.logs[logID]
+	if !ok {
+		return nil, nil
+	}
+	result := make([]string, len(entries))
+	for i, e := range entries {
+		result[i] = e.data
+	}
+	return result, nil
+}
+
+// LastLog returns last log under logID from local index, NotFound error returns if no log.
+func (s *sharedStorage) LastLog(logID string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := s.logs[logID]
+	if len(entries) == 0 {
+		return "", errors.NotFoundf("LogData %s", logID)
+	}
+	return entries[len(entries)-1].data, nil
+}
+
+// LogIDs returns all logID in local index.
+func (s *sharedStorage) LogIDs() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	logIDs := make([]string, 0, len(s.logs))
+	for logID := range s.logs {
+		logIDs = append(logIDs, logID)
+	}
+	sort.Strings(logIDs)
+	return logIDs, nil
+}
+
+// Cleanup writes tombstone for every entry of logID, compaction will remove them from topic later.
+func (s *sharedStorage) Cleanup(logID string) error {
+	s.mu.Lock()
+	entries := s.logs[logID]
+	keys := make([]string, len(entries))
+	for i, e := range entries {
+		keys[i] = e.key
+	}
+	s.mu.Unlock()
+	if len(keys) == 0 {
+		return nil
+	}
+	var tp topicPartition
+	var offset int64
+	for _, key := range keys {
+		var err error
+		if tp, offset, err = s.send(logID, key, nil); err != nil {
+			return errors.Annotatef(err, "Cleanup log %s failure", logID)
+		}
+	}
+	return s.waitApplied(tp, offset+1)
+}
+
+// Close stops consuming and closes Kafka clients.
+func (s *sharedStorage) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	for _, pc := range s.consumers {
+		pc.AsyncClose()
+	}
+	s.wg.Wait()
+	if s.producer != nil {
+		if err := s.producer.Close(); err != nil {
+			return errors.Annotate(err, "Close producer failure")
+		}
+	}
+	if s.consumer != nil {
+		if err := s.consumer.Close(); err != nil {
+			return errors.Annotate(err, "Close consumer failure")
+		}
+	}
+	if s.client != nil {
+		if err := s.client.Close(); err != nil {
+			return errors.Annotate(err, "Close client failure")
+		}
+	}
+	return nil
+}
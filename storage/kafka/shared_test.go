@@ -0,0 +1,189 @@
+package kafka
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeBroker keeps produced messages in memory and delivers them to attached storages as consumers do.
+type fakeBroker struct {
+	mu       sync.Mutex
+	messages map[topicPartition][]*sarama.ConsumerMessage
+	readers  []*fakeReader
+}
+
+type fakeReader struct {
+	s         *sharedStorage
+	delivered map[topicPartition]int
+	paused    bool
+}
+
+func newFakeBroker() *fakeBroker {
+	return &fakeBroker{messages: make(map[topicPartition][]*sarama.ConsumerMessage)}
+}
+
+// attach creates storage produces into broker and consumes from it.
+func (b *fakeBroker) attach() (*sharedStorage, *fakeReader) {
+	s := newSharedStorage([]string{"saga_a", "saga_b"}, 100*time.Millisecond)
+	s.partitions = map[string][]int32{"saga_a": {0, 1, 2}, "saga_b": {0, 1, 2}}
+	s.producer = b
+	r := &fakeReader{s: s, delivered: make(map[topicPartition]int)}
+	b.mu.Lock()
+	b.readers = append(b.readers, r)
+	b.mu.Unlock()
+	b.deliver()
+	return s, r
+}
+
+func (b *fakeBroker) pause(r *fakeReader, paused bool) {
+	b.mu.Lock()
+	r.paused = paused
+	b.mu.Unlock()
+	b.deliver()
+}
+
+func (b *fakeBroker) deliver() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, r := range b.readers {
+		if r.paused {
+			continue
+		}
+		for tp, messages := range b.messages {
+			for ; r.delivered[tp] < len(messages); r.delivered[tp]++ {
+				r.s.apply(messages[r.delivered[tp]])
+			}
+		}
+	}
+}
+
+func (b *fakeBroker) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	key, _ := msg.Key.Encode()
+	var value []byte
+	if msg.Value != nil {
+		value, _ = msg.Value.Encode()
+	}
+	tp := topicPartition{topic: msg.Topic, partition: msg.Partition}
+	b.mu.Lock()
+	offset := int64(len(b.messages[tp]))
+	b.messages[tp] = append(b.messages[tp], &sarama.ConsumerMessage{
+		Topic: msg.Topic, Partition: msg.Partition, Offset: offset, Key: key, Value: value,
+	})
+	b.mu.Unlock()
+	b.deliver()
+	return msg.Partition, offset, nil
+}
+
+func (b *fakeBroker) SendMessages(msgs []*sarama.ProducerMessage) error {
+	for _, msg := range msgs {
+		if _, _, err := b.SendMessage(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *fakeBroker) Close() error {
+	return nil
+}
+
+func TestSharedStorage(t *testing.T) {
+	broker := newFakeBroker()
+	s, _ := broker.attach()
+
+	assert.NoError(t, s.AppendLog("saga_1", "a"))
+	assert.NoError(t, s.AppendLog("saga_1", "b"))
+	assert.NoError(t, s.AppendLog("saga_2", "c"))
+
+	logs, err := s.Lookup("saga_1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, logs)
+	last, err := s.LastLog("saga_1")
+	assert.NoError(t, err)
+	assert.Equal(t, "b", last)
+	logIDs, err := s.LogIDs()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"saga_1", "saga_2"}, logIDs)
+
+	assert.NoError(t, s.Cleanup("saga_1"))
+	logs, err = s.Lookup("saga_1")
+	assert.NoError(t, err)
+	assert.Empty(t, logs)
+	_, err = s.LastLog("saga_1")
+	assert.True(t, errors.IsNotFound(err))
+	tombstones := 0
+	for _, messages := range broker.messages {
+		for _, msg := range messages {
+			if msg.Value == nil {
+				tombstones++
+			}
+		}
+	}
+	assert.Equal(t, 2, tombstones)
+
+	// restarted storage rebuilds same index from topic
+	restarted, _ := broker.attach()
+	logIDs, err = restarted.LogIDs()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"saga_2"}, logIDs)
+	logs, err = restarted.Lookup("saga_2")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"c"}, logs)
+
+	assert.NoError(t, s.Close())
+	assert.NoError(t, restarted.Close())
+}
+
+func TestSharedStaleWriter(t *testing.T) {
+	broker := newFakeBroker()
+	a, _ := broker.attach()
+	b, stale := broker.attach()
+
+	broker.pause(stale, true)
+	assert.NoError(t, a.AppendLog("saga_1", "a1"))
+	assert.NoError(t, a.AppendLog("saga_1", "a2"))
+	logs, err := b.Lookup("saga_1")
+	assert.NoError(t, err)
+	assert.Empty(t, logs)
+
+	// append of stale writer waits it's index catch up, and never overwrites entries of others
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		broker.pause(stale, false)
+	}()
+	assert.NoError(t, b.AppendLog("saga_1", "b1"))
+	for _, s := range []*sharedStorage{a, b} {
+		logs, err := s.Lookup("saga_1")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"a1", "a2", "b1"}, logs)
+	}
+
+	// append times out if index can not catch up
+	broker.pause(stale, true)
+	assert.True(t, errors.IsTimeout(b.AppendLog("saga_1", "b2")))
+	broker.pause(stale, false)
+
+	assert.NoError(t, b.Cleanup("saga_1"))
+	for _, s := range []*sharedStorage{a, b} {
+		logIDs, err := s.LogIDs()
+		assert.NoError(t, err)
+		assert.Empty(t, logIDs)
+	}
+}
+
+func TestSharedRoute(t *testing.T) {
+	s, _ := newFakeBroker().attach()
+	used := make(map[topicPartition]bool)
+	for i := 0; i < 100; i++ {
+		logID := "saga_" + string(rune('a'+i%26)) + string(rune('a'+i/26))
+		tp := s.route(logID)
+		assert.Equal(t, tp, s.route(logID))
+		used[tp] = true
+	}
+	assert.Len(t, used, 6)
+}
@@ -1,55 +1,50 @@
 package memory
 
 import (
+	"sync"
+
 	"github.com/juju/errors"
-	"github.com/lysu/go-saga"
 	"github.com/lysu/go-saga/storage"
-	"sync"
 )
 
-var storageInstance storage.Storage
-var memoryInit sync.Once
-
 func init() {
-	saga.StorageProvider = func(cfg storage.StorageConfig) storage.Storage {
-		memoryInit.Do(func() {
-			var err error
-			storageInstance, err = newMemStorage()
-			if err != nil {
-				panic(err)
-			}
-		})
-		return storageInstance
-	}
+	storage.Register("memory", func(config interface{}) (storage.Storage, error) {
+		return New(), nil
+	})
 }
 
 type memStorage struct {
+	mu   sync.RWMutex
 	data map[string][]string
 }
 
-// NewMemStorage creates log storage base on memory.
-// This storage use simple `map[string][]string`, just for TestCase used.
+// New creates an independent log storage base on memory.
+// This storage use simple `map[string][]string` guarded by lock, just for TestCase used.
+// It's safe for concurrent use and returns copies of logs, each instance has it's own data.
 // NOT use this in product.
-func newMemStorage() (storage.Storage, error) {
+func New() storage.Storage {
 	return &memStorage{
 		data: make(map[string][]string),
-	}, nil
+	}
 }
 
 // AppendLog appends log into queue under given logID.
 func (s *memStorage) AppendLog(logID string, data string) error {
-	logQueue, ok := s.data[logID]
-	if !ok {
-		logQueue = []string{}
-		s.data[logID] = logQueue
-	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.data[logID] = append(s.data[logID], data)
 	return nil
 }
 
-// Lookup lookups log under given logID.
+// Lookup lookups log under given logID, it returns a copy of logs.
 func (s *memStorage) Lookup(logID string) ([]string, error) {
-	return s.data[logID], nil
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	logs := s.data[logID]
+	if logs == nil {
+		return nil, nil
+	}
+	return append([]string(nil), logs...), nil
 }
 
 // Close uses to close storage and release resources.
@@ -59,6 +54,8 @@ func (s *memStorage) Close() error {
 
 // LogIDs uses to take all Log ID av in current storage
 func (s *memStorage) LogIDs() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	ids := make([]string, 0, len(s.data))
 	for id := range s.data {
 		ids = append(ids, id)
@@ -67,11 +64,15 @@ func (s *memStorage) LogIDs() ([]string, error) {
 }
 
 func (s *memStorage) Cleanup(logID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	delete(s.data, logID)
 	return nil
 }
 
 func (s *memStorage) LastLog(logID string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	logData, ok := s.data[logID]
 	if !ok {
 		err := errors.NewErr("LogData %s not found", logID)
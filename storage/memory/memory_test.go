@@ -1,16 +1,49 @@
 package memory
 
 import (
+	"fmt"
 	"github.com/stretchr/testify/assert"
+	"sync"
 	"testing"
 )
 
 func TestMemStorage(t *testing.T) {
-	s, err := newMemStorage()
-	assert.NoError(t, err)
-	err = s.AppendLog("t_11", "{}")
+	s := New()
+	err := s.AppendLog("t_11", "{}")
 	assert.NoError(t, err)
 	looked, err := s.Lookup("t_11")
 	assert.NoError(t, err)
 	assert.Contains(t, looked, "{}")
 }
+
+func TestMemStorageConcurrent(t *testing.T) {
+	s1, s2 := New(), New()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			logID := fmt.Sprintf("t_%d", i%2)
+			assert.NoError(t, s1.AppendLog(logID, "{}"))
+			_, err := s1.Lookup(logID)
+			assert.NoError(t, err)
+			_, err = s1.LogIDs()
+			assert.NoError(t, err)
+			_, err = s1.LastLog(logID)
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+
+	looked, err := s1.Lookup("t_0")
+	assert.NoError(t, err)
+	assert.Equal(t, 5, len(looked))
+	looked[0] = "changed"
+	looked, err = s1.Lookup("t_0")
+	assert.NoError(t, err)
+	assert.Equal(t, "{}", looked[0])
+
+	ids, err := s2.LogIDs()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(ids))
+}
@@ -0,0 +1,175 @@
+// Package redis provides saga log storage base on Redis streams.
+package redis
+
+import (
+	"sort"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/lysu/go-saga/storage"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/net/context"
+)
+
+func init() {
+	storage.Register("redis", func(config interface{}) (storage.Storage, error) {
+		switch cfg := config.(type) {
+		case Config:
+			return New(cfg)
+		case *Config:
+			return New(*cfg)
+		default:
+			return nil, errors.NotValidf("redis storage config %T", config)
+		}
+	})
+}
+
+// DefaultPrefix is default prefix of keys used by storage.
+const DefaultPrefix = "saga:"
+
+// dataField is stream entry field holds log data.
+const dataField = "data"
+
+// Config presents config of redis storage.
+type Config struct {
+	// Client is used instead of creating by Addr, Password and DB if not nil, it's not closed by storage.
+	Client redis.UniversalClient
+	// Addr is address of redis server to create client.
+	Addr string
+	// Password and DB are auth password and database number to create client.
+	Password string
+	DB       int
+	// Prefix prefixes all keys as hash tag, default is DefaultPrefix.
+	Prefix string
+	// Timeout limits each operation, zero means no limit.
+	Timeout time.Duration
+}
+
+type redisStorage struct {
+	client  redis.UniversalClient
+	owned   bool
+	prefix  string
+	timeout time.Duration
+}
+
+// New creates log storage base on Redis streams.
+//
+// Each logID is a stream at key `{<prefix>}log:<logID>` with one entry per log,
+// and logIDs are indexed in set at key `{<prefix>}logids`.
+// Prefix is hash tag of keys, so all keys are in same slot of Redis Cluster and
+// transaction updates stream and index atomically, all logs live in one cluster node then.
+func New(cfg Config) (storage.Storage, error) {
+	s := &redisStorage{client: cfg.Client, prefix: cfg.Prefix, timeout: cfg.Timeout}
+	if s.prefix == "" {
+		s.prefix = DefaultPrefix
+	}
+	if s.client == nil {
+		if cfg.Addr == "" {
+			return nil, errors.NotValidf("empty redis address")
+		}
+		s.client = redis.NewClient(&redis.Options{Addr: cfg.Addr, Password: cfg.Password, DB: cfg.DB})
+		s.owned = true
+	}
+	ctx, cancel := s.context()
+	defer cancel()
+	if err := s.client.Ping(ctx).Err(); err != nil {
+		if s.owned {
+			_ = s.client.Close()
+		}
+		return nil, errors.Annotatef(err, "Connect redis %s failure", cfg.Addr)
+	}
+	return s, nil
+}
+
+func (s *redisStorage) context() (context.Context, context.CancelFunc) {
+	if s.timeout > 0 {
+		return context.WithTimeout(context.Background(), s.timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+func (s *redisStorage) streamKey(logID string) string {
+	return "{" + s.prefix + "}log:" + logID
+}
+
+func (s *redisStorage) indexKey() string {
+	return "{" + s.prefix + "}logids"
+}
+
+// AppendLog adds log into stream of logID and indexes logID in a transaction.
+func (s *redisStorage) AppendLog(logID string, data string) error {
+	ctx, cancel := s.context()
+	defer cancel()
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAdd(ctx, &redis.XAddArgs{Stream: s.streamKey(logID), Values: []interface{}{dataField, data}})
+		pipe.SAdd(ctx, s.indexKey(), logID)
+		return nil
+	})
+	return errors.Annotatef(err, "Append log %s failure", logID)
+}
+
+// Lookup lookups logs under given logID in stream order.
+func (s *redisStorage) Lookup(logID string) ([]string, error) {
+	ctx, cancel := s.context()
+	defer cancel()
+	messages, err := s.client.XRange(ctx, s.streamKey(logID), "-", "+").Result()
+	if err != nil {
+		return nil, errors.Annotatef(err, "Lookup log %s failure", logID)
+	}
+	data := make([]string, 0, len(messages))
+	for _, msg := range messages {
+		data = append(data, messageData(msg))
+	}
+	return data, nil
+}
+
+func messageData(msg redis.XMessage) string {
+	data, _ := msg.Values[dataField].(string)
+	return data
+}
+
+// Close closes client if it's created by storage.
+func (s *redisStorage) Close() error {
+	if !s.owned {
+		return nil
+	}
+	return s.client.Close()
+}
+
+// LogIDs returns indexed logIDs.
+func (s *redisStorage) LogIDs() ([]string, error) {
+	ctx, cancel := s.context()
+	defer cancel()
+	ids, err := s.client.SMembers(ctx, s.indexKey()).Result()
+	if err != nil {
+		return nil, errors.Annotate(err, "List log IDs failure")
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// Cleanup deletes stream of logID and removes it from index in a transaction.
+func (s *redisStorage) Cleanup(logID string) error {
+	ctx, cancel := s.context()
+	defer cancel()
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, s.streamKey(logID))
+		pipe.SRem(ctx, s.indexKey(), logID)
+		return nil
+	})
+	return errors.Annotatef(err, "Cleanup log %s failure", logID)
+}
+
+// LastLog returns last log under logID, NotFound error returns if no log.
+func (s *redisStorage) LastLog(logID string) (string, error) {
+	ctx, cancel := s.context()
+	defer cancel()
+	messages, err := s.client.XRevRangeN(ctx, s.streamKey(logID), "+", "-", 1).Result()
+	if err != nil {
+		return "", errors.Annotatef(err, "Fetch last log %s failure", logID)
+	}
+	if len(messages) == 0 {
+		return "", errors.NotFoundf("LogData %s", logID)
+	}
+	return messageData(messages[0]), nil
+}
@@ -0,0 +1,46 @@
+package redis
+
+import (
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedisStorage(t *testing.T) {
+	mr := miniredis.RunT(t)
+	s, err := New(Config{Addr: mr.Addr()})
+	assert.NoError(t, err)
+
+	err = s.AppendLog("t_11", "{}")
+	assert.NoError(t, err)
+	err = s.AppendLog("t_11", `{"type":2}`)
+	assert.NoError(t, err)
+	err = s.AppendLog("t_12", "{}")
+	assert.NoError(t, err)
+	looked, err := s.Lookup("t_11")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"{}", `{"type":2}`}, looked)
+	last, err := s.LastLog("t_11")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"type":2}`, last)
+	ids, err := s.LogIDs()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"t_11", "t_12"}, ids)
+
+	assert.NoError(t, s.Cleanup("t_11"))
+	looked, err = s.Lookup("t_11")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(looked))
+	_, err = s.LastLog("t_11")
+	assert.True(t, errors.IsNotFound(err))
+	assert.False(t, mr.Exists("{saga:}log:t_11"))
+	// keys share hash tag, so they are in same cluster slot
+	assert.True(t, mr.Exists("{saga:}log:t_12"))
+	assert.True(t, mr.Exists("{saga:}logids"))
+	ids, err = s.LogIDs()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"t_12"}, ids)
+	assert.NoError(t, s.Close())
+}
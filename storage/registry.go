@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/juju/errors"
+)
+
+// Factory creates storage by backend-specific config.
+type Factory func(config interface{}) (Storage, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]Factory)
+)
+
+// Register makes a storage backend available by given name.
+// It is usually called in init function of backend package,
+// and panics if Register is called twice with same name or factory is nil.
+func Register(name string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	if factory == nil {
+		panic("storage: Register factory is nil")
+	}
+	if _, dup := factories[name]; dup {
+		panic("storage: Register called twice for backend " + name)
+	}
+	factories[name] = factory
+}
+
+// Open opens storage by registered backend name and it's backend-specific config.
+func Open(name string, config interface{}) (Storage, error) {
+	factoriesMu.RLock()
+	factory, ok := factories[name]
+	factoriesMu.RUnlock()
+	if !ok {
+		return nil, errors.NotFoundf("storage backend %q (forgotten import?)", name)
+	}
+	return factory(config)
+}
+
+// Backends returns sorted names of registered backends.
+func Backends() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
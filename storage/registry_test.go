@@ -0,0 +1,28 @@
+package storage
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRegister(t *testing.T) {
+	var opened interface{}
+	Register("fake", func(config interface{}) (Storage, error) {
+		opened = config
+		return nil, nil
+	})
+	assert.Contains(t, Backends(), "fake")
+
+	_, err := Open("fake", "cfg")
+	assert.NoError(t, err)
+	assert.Equal(t, "cfg", opened)
+
+	_, err = Open("unknown", nil)
+	assert.Error(t, err)
+
+	assert.Panics(t, func() {
+		Register("fake", func(config interface{}) (Storage, error) {
+			return nil, nil
+		})
+	})
+}
@@ -0,0 +1,98 @@
+package sql
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Dialect presents SQL differences of a database.
+type Dialect struct {
+	Name string
+	// Placeholder returns bind parameter for n-th(start from 1) argument.
+	Placeholder func(n int) string
+	// KeyType is column type of log ID, it must be indexable.
+	KeyType string
+	// DataType is column type of log data.
+	DataType string
+	// IsConflict reports whether err is unique key violation, it's used to retry append on sequence conflict.
+	IsConflict func(err error) bool
+}
+
+var (
+	// SQLite is dialect of SQLite.
+	SQLite = Dialect{
+		Name:        "sqlite",
+		Placeholder: questionPlaceholder,
+		KeyType:     "TEXT",
+		DataType:    "TEXT",
+		IsConflict:  errorContains("UNIQUE constraint failed"),
+	}
+	// Postgres is dialect of PostgreSQL.
+	Postgres = Dialect{
+		Name:        "postgres",
+		Placeholder: dollarPlaceholder,
+		KeyType:     "VARCHAR(255)",
+		DataType:    "TEXT",
+		IsConflict:  errorContains("SQLSTATE 23505", "duplicate key value violates unique constraint"),
+	}
+	// MySQL is dialect of MySQL.
+	MySQL = Dialect{
+		Name:        "mysql",
+		Placeholder: questionPlaceholder,
+		KeyType:     "VARCHAR(255)",
+		DataType:    "LONGTEXT",
+		IsConflict:  errorContains("Error 1062", "Duplicate entry"),
+	}
+)
+
+// dialects maps dialect and driver names to dialect.
+var dialects = map[string]Dialect{
+	"sqlite":   SQLite,
+	"sqlite3":  SQLite,
+	"postgres": Postgres,
+	"pgx":      Postgres,
+	"mysql":    MySQL,
+}
+
+func questionPlaceholder(n int) string {
+	return "?"
+}
+
+func dollarPlaceholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+// errorContains returns IsConflict matches error message by given patterns,
+// so drivers need not be imported.
+func errorContains(patterns ...string) func(err error) bool {
+	return func(err error) bool {
+		if err == nil {
+			return false
+		}
+		msg := err.Error()
+		for _, pattern := range patterns {
+			if strings.Contains(msg, pattern) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// bind replaces `?` in query with placeholders of dialect.
+func (d Dialect) bind(query string) string {
+	if d.Placeholder == nil {
+		return query
+	}
+	var buf strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			buf.WriteString(d.Placeholder(n))
+			continue
+		}
+		buf.WriteRune(c)
+	}
+	return buf.String()
+}
@@ -0,0 +1,245 @@
+// Package sql provides saga log storage over database/sql.
+package sql
+
+import (
+	stdsql "database/sql"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/lysu/go-saga/storage"
+)
+
+func init() {
+	storage.Register("sql", func(config interface{}) (storage.Storage, error) {
+		switch cfg := config.(type) {
+		case Config:
+			return New(cfg)
+		case *Config:
+			return New(*cfg)
+		default:
+			return nil, errors.NotValidf("sql storage config %T", config)
+		}
+	})
+}
+
+// DefaultTable is default table name of saga log.
+const DefaultTable = "saga_log"
+
+const (
+	// appendRetries is times to retry append when sequence number conflicts with concurrent append.
+	appendRetries = 5
+	// appendBackoff is backoff before first retry of append, it's doubled for every retry.
+	appendBackoff = 10 * time.Millisecond
+)
+
+// Config presents config of SQL storage.
+type Config struct {
+	// DB is opened database, it's used instead of opening by Driver and DSN if not nil.
+	DB *stdsql.DB
+	// Driver and DSN are used to open database by database/sql.
+	Driver, DSN string
+	// Dialect is name of dialect(sqlite, postgres or mysql), Driver is used to find dialect if it's empty.
+	Dialect string
+	// Table is table name of saga log, default is DefaultTable.
+	// Schema version is kept in table with `_version` suffix.
+	Table string
+}
+
+type sqlStorage struct {
+	db      *stdsql.DB
+	owned   bool
+	dialect Dialect
+	table   string
+	// mu serializes append in process, conflict between processes is resolved by primary key and retry.
+	mu sync.Mutex
+}
+
+var tableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// New creates log storage base on database/sql, schema is created or migrated to latest version.
+//
+// Logs are saved as rows of (log_id, seq, data), seq is sequence number of log under log_id start from 1.
+func New(cfg Config) (storage.Storage, error) {
+	name := cfg.Dialect
+	if name == "" {
+		name = cfg.Driver
+	}
+	dialect, ok := dialects[name]
+	if !ok {
+		return nil, errors.NotSupportedf("sql dialect %q", name)
+	}
+	table := cfg.Table
+	if table == "" {
+		table = DefaultTable
+	}
+	if !tableName.MatchString(table) {
+		return nil, errors.NotValidf("table name %q", table)
+	}
+	s := &sqlStorage{db: cfg.DB, dialect: dialect, table: table}
+	if s.db == nil {
+		db, err := stdsql.Open(cfg.Driver, cfg.DSN)
+		if err != nil {
+			return nil, errors.Annotatef(err, "Open %s database failure", cfg.Driver)
+		}
+		s.db, s.owned = db, true
+	}
+	if err := s.migrate(); err != nil {
+		if s.owned {
+			_ = s.db.Close()
+		}
+		return nil, err
+	}
+	return s, nil
+}
+
+// migrations are schema changes in order, schema version is number of migrations applied.
+var migrations = []func(d Dialect, table string) []string{
+	func(d Dialect, table string) []string {
+		return []string{fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	log_id %s NOT NULL,
+	seq BIGINT NOT NULL,
+	data %s NOT NULL,
+	PRIMARY KEY (log_id, seq)
+)`, table, d.KeyType, d.DataType)}
+	},
+}
+
+// migrate applies migrations not yet applied in a transaction.
+func (s *sqlStorage) migrate() error {
+	versionTable := s.table + "_version"
+	_, err := s.db.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (version INT NOT NULL)", versionTable))
+	if err != nil {
+		return errors.Annotatef(err, "Create table %s failure", versionTable)
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer tx.Rollback()
+	var version int
+	err = tx.QueryRow(fmt.Sprintf("SELECT COALESCE(MAX(version), 0) FROM %s", versionTable)).Scan(&version)
+	if err != nil {
+		return errors.Annotatef(err, "Query schema version failure")
+	}
+	if version > len(migrations) {
+		return errors.NotSupportedf("schema version %d of table %s", version, s.table)
+	}
+	for ; version < len(migrations); version++ {
+		for _, stmt := range migrations[version](s.dialect, s.table) {
+			if _, err := tx.Exec(stmt); err != nil {
+				return errors.Annotatef(err, "Migrate table %s to version %d failure", s.table, version+1)
+			}
+		}
+		_, err := tx.Exec(s.dialect.bind(fmt.Sprintf("INSERT INTO %s (version) VALUES (?)", versionTable)), version+1)
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return errors.Trace(tx.Commit())
+}
+
+// AppendLog appends log under given logID with next sequence number.
+//
+// Append is retried with backoff only when sequence number is taken by concurrent append of other process,
+// other errors return immediately since the log may have been committed already.
+func (s *sqlStorage) AppendLog(logID string, data string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	backoff := appendBackoff
+	for i := 0; ; i++ {
+		conflict, err := s.appendLog(logID, data)
+		if err == nil {
+			return nil
+		}
+		if !conflict || i == appendRetries {
+			return errors.Annotatef(err, "Append log %s failure", logID)
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// appendLog appends log in a transaction, conflict is true if insert violates primary key.
+func (s *sqlStorage) appendLog(logID string, data string) (conflict bool, err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	var seq int64
+	err = tx.QueryRow(s.dialect.bind(fmt.Sprintf("SELECT COALESCE(MAX(seq), 0) FROM %s WHERE log_id = ?", s.table)), logID).Scan(&seq)
+	if err != nil {
+		return false, err
+	}
+	_, err = tx.Exec(s.dialect.bind(fmt.Sprintf("INSERT INTO %s (log_id, seq, data) VALUES (?, ?, ?)", s.table)), logID, seq+1, data)
+	if err != nil {
+		return s.dialect.IsConflict != nil && s.dialect.IsConflict(err), err
+	}
+	return false, tx.Commit()
+}
+
+// Lookup lookups logs under given logID in sequence order.
+func (s *sqlStorage) Lookup(logID string) ([]string, error) {
+	rows, err := s.db.Query(s.dialect.bind(fmt.Sprintf("SELECT data FROM %s WHERE log_id = ? ORDER BY seq", s.table)), logID)
+	if err != nil {
+		return nil, errors.Annotatef(err, "Lookup log %s failure", logID)
+	}
+	defer rows.Close()
+	data := []string{}
+	for rows.Next() {
+		var d string
+		if err := rows.Scan(&d); err != nil {
+			return nil, errors.Trace(err)
+		}
+		data = append(data, d)
+	}
+	return data, errors.Trace(rows.Err())
+}
+
+// Close closes database if it's opened by storage.
+func (s *sqlStorage) Close() error {
+	if !s.owned {
+		return nil
+	}
+	return s.db.Close()
+}
+
+// LogIDs returns logIDs have logs.
+func (s *sqlStorage) LogIDs() ([]string, error) {
+	rows, err := s.db.Query(fmt.Sprintf("SELECT DISTINCT log_id FROM %s ORDER BY log_id", s.table))
+	if err != nil {
+		return nil, errors.Annotate(err, "Query log IDs failure")
+	}
+	defer rows.Close()
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, errors.Trace(err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, errors.Trace(rows.Err())
+}
+
+// Cleanup deletes all logs under logID.
+func (s *sqlStorage) Cleanup(logID string) error {
+	_, err := s.db.Exec(s.dialect.bind(fmt.Sprintf("DELETE FROM %s WHERE log_id = ?", s.table)), logID)
+	return errors.Annotatef(err, "Cleanup log %s failure", logID)
+}
+
+// LastLog returns log with max sequence number under logID, NotFound error returns if no log.
+func (s *sqlStorage) LastLog(logID string) (string, error) {
+	var data string
+	err := s.db.QueryRow(s.dialect.bind(fmt.Sprintf("SELECT data FROM %s WHERE log_id = ? ORDER BY seq DESC LIMIT 1", s.table)), logID).Scan(&data)
+	if err == stdsql.ErrNoRows {
+		return "", errors.NotFoundf("LogData %s", logID)
+	}
+	if err != nil {
+		return "", errors.Annotatef(err, "Fetch last log %s failure", logID)
+	}
+	return data, nil
+}
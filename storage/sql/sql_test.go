@@ -0,0 +1,92 @@
+package sql
+
+import (
+	stdsql "database/sql"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/lysu/go-saga/storage"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSQLStorage(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "saga.db")
+	s, err := storage.Open("sql", Config{Driver: "sqlite3", DSN: dsn})
+	assert.NoError(t, err)
+
+	_, err = s.LastLog("saga_1")
+	assert.True(t, errors.IsNotFound(err))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, s.AppendLog("saga_1", "{}"))
+		}()
+	}
+	wg.Wait()
+	assert.NoError(t, s.AppendLog("saga_1", `{"type":2}`))
+	assert.NoError(t, s.AppendLog("saga_2", "{}"))
+
+	looked, err := s.Lookup("saga_1")
+	assert.NoError(t, err)
+	assert.Equal(t, 11, len(looked))
+	last, err := s.LastLog("saga_1")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"type":2}`, last)
+	ids, err := s.LogIDs()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"saga_1", "saga_2"}, ids)
+
+	assert.NoError(t, s.Cleanup("saga_1"))
+	looked, err = s.Lookup("saga_1")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(looked))
+	assert.NoError(t, s.Close())
+
+	// reopen migrated database
+	s, err = New(Config{Driver: "sqlite3", DSN: dsn})
+	assert.NoError(t, err)
+	looked, err = s.Lookup("saga_2")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"{}"}, looked)
+	assert.NoError(t, s.Close())
+}
+
+func TestDialect(t *testing.T) {
+	query := "INSERT INTO t (a, b) VALUES (?, ?)"
+	assert.Equal(t, "INSERT INTO t (a, b) VALUES ($1, $2)", Postgres.bind(query))
+	assert.Equal(t, query, MySQL.bind(query))
+
+	_, err := New(Config{Driver: "unknown"})
+	assert.True(t, errors.IsNotSupported(err))
+}
+
+func TestAppendConflict(t *testing.T) {
+	db, err := stdsql.Open("sqlite3", filepath.Join(t.TempDir(), "saga.db"))
+	assert.NoError(t, err)
+	defer db.Close()
+	s, err := New(Config{DB: db, Dialect: "sqlite"})
+	assert.NoError(t, err)
+	assert.NoError(t, s.AppendLog("saga_1", "{}"))
+
+	_, err = db.Exec("INSERT INTO saga_log (log_id, seq, data) VALUES (?, ?, ?)", "saga_1", 1, "{}")
+	assert.True(t, SQLite.IsConflict(err))
+	_, err = db.Exec("INSERT INTO missing (log_id) VALUES (?)", "saga_1")
+	assert.False(t, SQLite.IsConflict(err))
+	assert.True(t, Postgres.IsConflict(fmt.Errorf("pq: duplicate key value violates unique constraint \"saga_log_pkey\"")))
+	assert.True(t, MySQL.IsConflict(fmt.Errorf("Error 1062 (23000): Duplicate entry 'saga_1-2' for key 'PRIMARY'")))
+
+	// other errors are not retried
+	_, err = db.Exec("DROP TABLE saga_log")
+	assert.NoError(t, err)
+	start := time.Now()
+	assert.Error(t, s.AppendLog("saga_1", "{}"))
+	assert.True(t, time.Since(start) < appendBackoff)
+}
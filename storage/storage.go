@@ -1,7 +1,5 @@
 package storage
 
-import "time"
-
 // Storage uses to support save and lookup saga log.
 type Storage interface {
 
@@ -24,12 +22,11 @@ type Storage interface {
 	LastLog(logID string) (string, error)
 }
 
+// StorageProvider provides storage by given config.
 type StorageProvider func(cfg StorageConfig) Storage
 
+// StorageConfig presents which registered backend and backend-specific config to open storage.
 type StorageConfig struct {
-	Kafka struct {
-		ZkAddrs, BrokerAddrs []string
-		Partitions, Replicas int
-		ReturnDuration       time.Duration
-	}
+	Backend string
+	Config  interface{}
 }
@@ -5,7 +5,8 @@ import (
 
 	"fmt"
 	"github.com/lysu/go-saga"
-	_ "github.com/lysu/go-saga/storage/kafka"
+	"github.com/lysu/go-saga/storage"
+	"github.com/lysu/go-saga/storage/kafka"
 	"github.com/stretchr/testify/assert"
 	"testing"
 	"time"
@@ -13,11 +14,16 @@ import (
 
 func initKafka(mode FailureMode) {
 
-	saga.StorageConfig.Kafka.ZkAddrs = []string{"0.0.0.0:2181"}
-	saga.StorageConfig.Kafka.BrokerAddrs = []string{"0.0.0.0:9092"}
-	saga.StorageConfig.Kafka.Partitions = 1
-	saga.StorageConfig.Kafka.Replicas = 1
-	saga.StorageConfig.Kafka.ReturnDuration = 50 * time.Millisecond
+	saga.StorageConfig = storage.StorageConfig{
+		Backend: "kafka",
+		Config: kafka.Config{
+			ZkAddrs:        []string{"0.0.0.0:2181"},
+			BrokerAddrs:    []string{"0.0.0.0:9092"},
+			Partitions:     1,
+			Replicas:       1,
+			ReturnDuration: 50 * time.Millisecond,
+		},
+	}
 
 	saga.AddSubTxDef("deduce", DeduceAccount, CompensateDeduce).
 		AddSubTxDef("deposit", DepositAccount, CompensateDeposit).
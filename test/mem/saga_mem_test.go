@@ -1,12 +1,20 @@
 package saga_test
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/lysu/go-saga"
-	_ "github.com/lysu/go-saga/storage/memory"
+	"github.com/lysu/go-saga/lease"
+	"github.com/lysu/go-saga/metrics"
+	"github.com/lysu/go-saga/storage/memory"
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/net/context"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func initIt(mode FailureMode) {
@@ -65,7 +73,7 @@ func TestDepositFail(t *testing.T) {
 
 	// assert
 	assert.Equal(t, 200, memDB[from])
-	assert.Equal(t, -100, memDB[to]) // BUG fix test
+	assert.Equal(t, 0, memDB[to]) // failed deposit is not compensated
 
 	logs, err := saga.LogStorage().Lookup("saga_1")
 	assert.NoError(t, err)
@@ -74,6 +82,476 @@ func TestDepositFail(t *testing.T) {
 
 }
 
+func TestRecoverUnfinished(t *testing.T) {
+
+	initIt(OK)
+
+	from, to := "foo", "bar"
+	amount := 100
+
+	ctx := context.Background()
+
+	// crash after deduce without EndSaga
+	var sagaID uint64 = 3
+	saga.StartSaga(ctx, sagaID).
+		ExecSub("deduce", from, amount)
+	assert.Equal(t, 100, memDB[from])
+
+	results, err := saga.DefaultSEC.StartCoordinator()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(results))
+	assert.Equal(t, sagaID, results[0].SagaID)
+	assert.Equal(t, saga.RecoveryCompensated, results[0].Outcome)
+	assert.NoError(t, results[0].Err)
+
+	assert.Equal(t, 200, memDB[from])
+	assert.Equal(t, 0, memDB[to])
+
+	logs, err := saga.LogStorage().Lookup("saga_3")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(logs))
+
+}
+
+func TestExecError(t *testing.T) {
+
+	initIt(DepositFail)
+
+	from, to := "foo", "bar"
+	amount := 100
+
+	ctx := context.Background()
+
+	var sagaID uint64 = 4
+	s, err := saga.Start(ctx, sagaID)
+	assert.NoError(t, err)
+
+	err = s.Exec("unknown", from, amount)
+	var unknownErr *saga.UnknownSubTxError
+	assert.True(t, errors.As(err, &unknownErr))
+	assert.Equal(t, "unknown", unknownErr.SubTxID)
+
+	assert.NoError(t, s.Exec("deduce", from, amount))
+
+	err = s.Exec("deposit", to, amount)
+	var actionErr *saga.ActionError
+	assert.True(t, errors.As(err, &actionErr))
+	assert.Equal(t, "deposit", actionErr.SubTxID)
+	assert.EqualError(t, actionErr.Err, "Deposit failure")
+	assert.Equal(t, 200, memDB[from])
+
+	err = s.Exec("deduce", from, amount)
+	assert.True(t, errors.Is(err, saga.ErrSagaAborted))
+	assert.Equal(t, 200, memDB[from])
+
+	assert.NoError(t, s.End())
+
+}
+
+func TestRetryAction(t *testing.T) {
+
+	initIt(OK)
+
+	failures := 2
+	flaky := func(ctx context.Context, account string, amount int) error {
+		if failures > 0 {
+			failures--
+			return fmt.Errorf("Flaky failure")
+		}
+		memDB[account] = (memDB[account] - amount)
+		return nil
+	}
+	saga.AddSubTxDef("flaky", flaky, CompensateDeduce, saga.WithRetry(saga.RetryPolicy{MaxAttempts: 3}))
+
+	ctx := context.Background()
+
+	var sagaID uint64 = 5
+	s, err := saga.Start(ctx, sagaID)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Exec("flaky", "foo", 100))
+	assert.Equal(t, 100, memDB["foo"])
+
+	logs, err := saga.LogStorage().Lookup("saga_5")
+	assert.NoError(t, err)
+	retries := 0
+	for _, data := range logs {
+		var l saga.Log
+		assert.NoError(t, json.Unmarshal([]byte(data), &l))
+		if l.Type == saga.ActionRetry {
+			retries++
+			assert.Equal(t, retries+1, l.Attempt)
+			assert.Equal(t, "Flaky failure", l.Error)
+		}
+	}
+	assert.Equal(t, 2, retries)
+
+	assert.NoError(t, s.End())
+
+}
+
+func TestCompensateStuck(t *testing.T) {
+
+	initIt(DepositFail)
+
+	compensated := 0
+	failCompensate := func(ctx context.Context, account string, amount int) error {
+		compensated++
+		return fmt.Errorf("Compensate failure")
+	}
+	saga.AddSubTxDef("stuck", DeduceAccount, failCompensate,
+		saga.WithCompensateRetry(saga.RetryPolicy{MaxAttempts: 2}))
+
+	ctx := context.Background()
+
+	var sagaID uint64 = 6
+	s, err := saga.Start(ctx, sagaID)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Exec("stuck", "foo", 100))
+
+	err = s.Exec("deposit", "bar", 100)
+	var compensateErr *saga.CompensateError
+	assert.True(t, errors.As(err, &compensateErr))
+	assert.Equal(t, "stuck", compensateErr.SubTxID)
+	assert.Equal(t, 2, compensateErr.Attempts)
+	assert.True(t, compensateErr.Stuck)
+	assert.Equal(t, 2, compensated)
+
+	results, err := saga.DefaultSEC.StartCoordinator()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(results))
+	assert.Equal(t, saga.RecoveryStuck, results[0].Outcome)
+	assert.True(t, errors.As(results[0].Err, &compensateErr))
+	assert.Equal(t, "Compensate failure", compensateErr.Err.Error())
+	assert.Equal(t, 2, compensated)
+
+	logs, err := saga.LogStorage().Lookup("saga_6")
+	assert.NoError(t, err)
+	assert.NotEqual(t, 0, len(logs))
+	assert.NoError(t, saga.LogStorage().Cleanup("saga_6"))
+
+}
+
+func TestExecParallel(t *testing.T) {
+
+	initIt(OK)
+
+	var mu sync.Mutex
+	stock := map[string]int{"w1": 10, "w2": 10, "w3": 0}
+	reserve := func(ctx context.Context, warehouse string, amount int) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if stock[warehouse] < amount {
+			return fmt.Errorf("Out of stock")
+		}
+		stock[warehouse] -= amount
+		return nil
+	}
+	cancelReserve := func(ctx context.Context, warehouse string, amount int) error {
+		mu.Lock()
+		defer mu.Unlock()
+		stock[warehouse] += amount
+		return nil
+	}
+	saga.AddSubTxDef("reserve", reserve, cancelReserve)
+
+	ctx := context.Background()
+
+	var sagaID uint64 = 7
+	s, err := saga.Start(ctx, sagaID)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Exec("deduce", "foo", 100))
+	assert.NoError(t, s.ExecParallel(
+		saga.NewSubTx("reserve", "w1", 5),
+		saga.NewSubTx("reserve", "w2", 5),
+	))
+	assert.Equal(t, 5, stock["w1"])
+	assert.Equal(t, 5, stock["w2"])
+
+	err = s.ExecParallel(
+		saga.NewSubTx("reserve", "w1", 1),
+		saga.NewSubTx("reserve", "w2", 1),
+		saga.NewSubTx("reserve", "w3", 1),
+	)
+	var actionErr *saga.ActionError
+	assert.True(t, errors.As(err, &actionErr))
+	assert.Equal(t, "reserve", actionErr.SubTxID)
+	assert.Equal(t, 6, actionErr.StepID)
+
+	assert.Equal(t, map[string]int{"w1": 10, "w2": 10, "w3": 0}, stock)
+	assert.Equal(t, 200, memDB["foo"])
+
+	assert.NoError(t, s.End())
+
+}
+
+func TestRunSaga(t *testing.T) {
+
+	initIt(OK)
+
+	var mu sync.Mutex
+	done := []string{}
+	undone := []string{}
+	step := func(ctx context.Context, name string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if name == "ship" && testMode == DepositFail {
+			return fmt.Errorf("Ship failure")
+		}
+		if name == "reserve" && testMode == DeduceFail {
+			return fmt.Errorf("Reserve failure")
+		}
+		done = append(done, name)
+		return nil
+	}
+	undo := func(ctx context.Context, name string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		undone = append(undone, name)
+		return nil
+	}
+	saga.AddSubTxDef("step", step, undo)
+	err := saga.AddSagaDef("order",
+		saga.StepDef{Name: "charge", SubTxID: "step"},
+		saga.StepDef{Name: "reserve", SubTxID: "step"},
+		saga.StepDef{Name: "pack", SubTxID: "step", DependsOn: []string{"charge", "reserve"}},
+		saga.StepDef{Name: "ship", SubTxID: "step", DependsOn: []string{"pack"}},
+	)
+	assert.NoError(t, err)
+
+	args := map[string][]interface{}{
+		"charge":  {"charge"},
+		"reserve": {"reserve"},
+		"pack":    {"pack"},
+		"ship":    {"ship"},
+	}
+	ctx := context.Background()
+
+	assert.NoError(t, saga.RunSaga(ctx, 8, "order", args))
+	assert.Equal(t, 4, len(done))
+	assert.Equal(t, "pack", done[2])
+	assert.Equal(t, "ship", done[3])
+	assert.Equal(t, 0, len(undone))
+
+	done = done[:0]
+	testMode = DepositFail
+	err = saga.RunSaga(ctx, 8, "order", args)
+	var actionErr *saga.ActionError
+	assert.True(t, errors.As(err, &actionErr))
+	assert.Equal(t, 4, actionErr.StepID)
+	assert.Equal(t, 3, len(undone))
+	assert.Equal(t, "pack", undone[0])
+	assert.NotContains(t, undone, "ship")
+
+	logs, err := saga.LogStorage().Lookup("saga_8")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(logs))
+
+	// failed node is not compensated, completed nodes are
+	done, undone = done[:0], undone[:0]
+	testMode = DeduceFail
+	err = saga.RunSaga(ctx, 26, "order", args)
+	assert.True(t, errors.As(err, &actionErr))
+	assert.Equal(t, 2, actionErr.StepID)
+	assert.Equal(t, []string{"charge"}, done)
+	assert.Equal(t, []string{"charge"}, undone)
+
+}
+
+func TestContextCancel(t *testing.T) {
+
+	initIt(OK)
+
+	compensateCtxErr := fmt.Errorf("not compensated")
+	compensate := func(ctx context.Context, account string, amount int) error {
+		compensateCtxErr = ctx.Err()
+		return CompensateDeduce(ctx, account, amount)
+	}
+	slow := func(ctx context.Context, account string, amount int) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	saga.AddSubTxDef("cancel", DeduceAccount, compensate).
+		AddSubTxDef("slow", slow, CompensateDeposit, saga.WithTimeout(10*time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var sagaID uint64 = 9
+	s, err := saga.Start(ctx, sagaID)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Exec("cancel", "foo", 100))
+	assert.Equal(t, 100, memDB["foo"])
+
+	cancel()
+	err = s.Exec("deposit", "bar", 100)
+	assert.Equal(t, context.Canceled, err)
+	assert.NoError(t, compensateCtxErr)
+	assert.Equal(t, 200, memDB["foo"])
+	assert.Equal(t, 0, memDB["bar"])
+	assert.NoError(t, s.End())
+
+	s, err = saga.Start(context.Background(), sagaID)
+	assert.NoError(t, err)
+	err = s.Exec("slow", "bar", 100)
+	var actionErr *saga.ActionError
+	assert.True(t, errors.As(err, &actionErr))
+	assert.Equal(t, context.DeadlineExceeded, actionErr.Err)
+	assert.NoError(t, s.End())
+
+}
+
+func TestStepResult(t *testing.T) {
+
+	initIt(DepositFail)
+
+	refunded := ""
+	charge := func(ctx context.Context, account string, amount int) (string, error) {
+		memDB[account] = (memDB[account] - amount)
+		return "pay-" + account, nil
+	}
+	refund := func(ctx context.Context, account string, amount int, paymentID string) error {
+		refunded = paymentID
+		memDB[account] = (memDB[account] + amount)
+		return nil
+	}
+	shipped := ""
+	ship := func(ctx context.Context, account string, amount int) error {
+		s, ok := saga.FromContext(ctx)
+		if !ok {
+			return fmt.Errorf("Saga not found")
+		}
+		if err := s.Result("charge", &shipped); err != nil {
+			return err
+		}
+		return DepositAccount(ctx, account, amount)
+	}
+	saga.AddSubTxDef("charge", charge, refund).
+		AddSubTxDef("ship", ship, CompensateDeposit)
+
+	ctx := context.Background()
+
+	var sagaID uint64 = 10
+	s, err := saga.Start(ctx, sagaID)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Exec("charge", "foo", 100))
+
+	var paymentID string
+	assert.NoError(t, s.Result("charge", &paymentID))
+	assert.Equal(t, "pay-foo", paymentID)
+
+	err = s.Exec("ship", "bar", 100)
+	assert.Error(t, err)
+	assert.Equal(t, "pay-foo", shipped)
+	assert.Equal(t, "pay-foo", refunded)
+	assert.Equal(t, 200, memDB["foo"])
+	assert.NoError(t, s.End())
+
+}
+
+func TestSagaStatus(t *testing.T) {
+
+	initIt(DepositFail)
+
+	ctx := context.Background()
+
+	var sagaID uint64 = 11
+	_, err := saga.SagaStatus(sagaID)
+	assert.Error(t, err)
+
+	s, err := saga.Start(ctx, sagaID)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Exec("deduce", "foo", 100))
+
+	status, err := saga.SagaStatus(sagaID)
+	assert.NoError(t, err)
+	assert.Equal(t, saga.StateRunning, status.State)
+	assert.Equal(t, 1, len(status.Steps))
+	assert.Equal(t, "deduce", status.Steps[0].SubTxID)
+	assert.Equal(t, saga.StepCompleted, status.Steps[0].State)
+	assert.Equal(t, 2, len(status.Steps[0].Params))
+	assert.False(t, status.Steps[0].Start.IsZero())
+
+	assert.Error(t, s.Exec("deposit", "bar", 100))
+
+	status, err = saga.SagaStatus(sagaID)
+	assert.NoError(t, err)
+	assert.Equal(t, saga.StateCompensated, status.State)
+	assert.Equal(t, 2, len(status.Steps))
+	assert.Equal(t, saga.StepCompensated, status.Steps[0].State)
+	assert.Equal(t, saga.StepFailed, status.Steps[1].State)
+	assert.Equal(t, "Deposit failure", status.Steps[1].Error)
+
+	assert.NoError(t, s.End())
+
+}
+
+type Transfer struct {
+	Account string
+	Amount  int
+}
+
+func TestDefineStep(t *testing.T) {
+
+	initIt(DepositFail)
+
+	deduce := saga.Define(&saga.DefaultSEC, "typedDeduce",
+		func(ctx context.Context, tr Transfer) error {
+			return DeduceAccount(ctx, tr.Account, tr.Amount)
+		},
+		func(ctx context.Context, tr Transfer) error {
+			return CompensateDeduce(ctx, tr.Account, tr.Amount)
+		})
+
+	ctx := context.Background()
+
+	var sagaID uint64 = 12
+	s, err := saga.Start(ctx, sagaID)
+	assert.NoError(t, err)
+	assert.NoError(t, deduce.Exec(s, Transfer{Account: "foo", Amount: 100}))
+	assert.Equal(t, 100, memDB["foo"])
+
+	status, err := saga.SagaStatus(sagaID)
+	assert.NoError(t, err)
+	assert.Equal(t, "typedDeduce", status.Steps[0].SubTxID)
+	assert.Equal(t, "Transfer", status.Steps[0].Params[0].ParamType)
+
+	assert.Error(t, s.Exec("deposit", "bar", 100))
+	assert.Equal(t, 200, memDB["foo"])
+	assert.NoError(t, s.End())
+
+}
+
+func TestCoordinatorStorage(t *testing.T) {
+
+	initIt(OK)
+
+	store1, store2 := memory.New(), memory.New()
+	sec1, sec2 := saga.NewSEC(store1), saga.NewSEC(store2)
+	sec1.AddSubTxDef("deduce", DeduceAccount, CompensateDeduce)
+	sec2.AddSubTxDef("deposit", DepositAccount, CompensateDeposit)
+
+	ctx := context.Background()
+
+	var sagaID uint64 = 13
+	s1 := sec1.StartSaga(ctx, sagaID).ExecSub("deduce", "foo", 100)
+	s2 := sec2.StartSaga(ctx, sagaID).ExecSub("deposit", "bar", 100)
+
+	logs1, err := store1.Lookup("saga_13")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(logs1))
+	logs2, err := store2.Lookup("saga_13")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(logs2))
+	logs, err := saga.LogStorage().Lookup("saga_13")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(logs))
+
+	s1.EndSaga()
+	s2.EndSaga()
+	assert.Equal(t, 100, memDB["foo"])
+	assert.Equal(t, 100, memDB["bar"])
+
+}
+
 type FailureMode int
 
 const (
@@ -116,3 +594,553 @@ func CompensateDeposit(ctx context.Context, account string, amount int) error {
 func PTest1(ctx context.Context, name *string, age int) {
 
 }
+
+func TestPivotForward(t *testing.T) {
+
+	initIt(OK)
+
+	available := false
+	notify := func(ctx context.Context, account string, amount int) error {
+		if !available {
+			return fmt.Errorf("Notify failure")
+		}
+		memDB[account] = memDB[account] + amount
+		return nil
+	}
+	saga.AddSubTxDef("deduce", DeduceAccount, CompensateDeduce)
+	saga.AddSubTxDef("transfer", DepositAccount, CompensateDeposit, saga.WithKind(saga.Pivot))
+	saga.AddSubTxDef("notify", notify, CompensateDeposit, saga.WithKind(saga.Retriable),
+		saga.WithRetry(saga.RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond}))
+
+	ctx := context.Background()
+
+	var sagaID uint64 = 14
+	s, err := saga.Start(ctx, sagaID)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Exec("deduce", "foo", 100))
+	assert.NoError(t, s.Exec("transfer", "bar", 100))
+
+	err = s.Exec("notify", "baz", 1)
+	var actionErr *saga.ActionError
+	assert.True(t, errors.As(err, &actionErr))
+	assert.Equal(t, saga.ErrSagaFinished, s.Exec("notify", "baz", 1))
+	assert.Equal(t, saga.ErrPivotCompleted, s.Rollback())
+	assert.Equal(t, err, s.End())
+	assert.NotPanics(t, s.EndSaga)
+
+	status, err := saga.SagaStatus(sagaID)
+	assert.NoError(t, err)
+	assert.Equal(t, saga.StateRunning, status.State)
+	assert.Equal(t, saga.Pivot, status.Steps[1].Kind)
+	assert.Equal(t, saga.StepFailed, status.Steps[2].State)
+	logs, err := saga.LogStorage().Lookup("saga_14")
+	assert.NoError(t, err)
+	assert.NotEqual(t, 0, len(logs))
+
+	available = true
+	results, err := saga.DefaultSEC.StartCoordinator()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(results))
+	assert.Equal(t, saga.RecoveryCompleted, results[0].Outcome)
+	assert.NoError(t, results[0].Err)
+	assert.Equal(t, 100, memDB["foo"])
+	assert.Equal(t, 100, memDB["bar"])
+	assert.Equal(t, 1, memDB["baz"])
+
+	logs, err = saga.LogStorage().Lookup("saga_14")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(logs))
+
+}
+
+func TestMiddleware(t *testing.T) {
+
+	initIt(OK)
+
+	sec := saga.NewSEC(memory.New())
+	sec.AddSubTxDef("deduce", DeduceAccount, CompensateDeduce)
+	sec.AddSubTxDef("deposit", DepositAccount, CompensateDeposit)
+
+	var invocations []string
+	sec.Use(func(next saga.Handler) saga.Handler {
+		return func(ctx context.Context, inv *saga.Invocation) error {
+			invocations = append(invocations, fmt.Sprintf("%d %s %s %v", inv.SagaID, inv.SubTxID, inv.Phase, inv.Args))
+			if err := next(ctx, inv); err != nil {
+				return fmt.Errorf("%s: %v", inv.SubTxID, err)
+			}
+			return nil
+		}
+	}, func(next saga.Handler) saga.Handler {
+		return func(ctx context.Context, inv *saga.Invocation) error {
+			if inv.Phase == saga.PhaseAction && inv.SubTxID == "deposit" {
+				return fmt.Errorf("Denied")
+			}
+			return next(ctx, inv)
+		}
+	})
+
+	ctx := context.Background()
+
+	var sagaID uint64 = 15
+	s := sec.StartSaga(ctx, sagaID)
+	assert.NoError(t, s.Exec("deduce", "foo", 100))
+	err := s.Exec("deposit", "bar", 100)
+	var actionErr *saga.ActionError
+	assert.True(t, errors.As(err, &actionErr))
+	assert.EqualError(t, actionErr.Err, "deposit: Denied")
+	assert.NoError(t, s.End())
+
+	assert.Equal(t, []string{
+		"15 deduce action [foo 100]",
+		"15 deposit action [bar 100]",
+		"15 deduce compensate [foo 100]",
+	}, invocations)
+	assert.Equal(t, 200, memDB["foo"])
+	assert.Equal(t, 0, memDB["bar"])
+
+}
+
+func TestListener(t *testing.T) {
+
+	initIt(OK)
+
+	sec := saga.NewSEC(memory.New())
+	sec.AddSubTxDef("deduce", DeduceAccount, CompensateDeduce)
+	sec.AddSubTxDef("deposit", DepositAccount, CompensateDeposit)
+
+	var events, asyncEvents []saga.Event
+	sec.AddListener(saga.ListenerFunc(func(event saga.Event) {
+		events = append(events, event)
+	}))
+	async := saga.NewAsyncListener(saga.ListenerFunc(func(event saga.Event) {
+		asyncEvents = append(asyncEvents, event)
+	}), 100)
+	sec.AddListener(async)
+
+	ctx := context.Background()
+
+	var sagaID uint64 = 16
+	testMode = DepositFail
+	s := sec.StartSaga(ctx, sagaID)
+	assert.NoError(t, s.Exec("deduce", "foo", 100))
+	err := s.Exec("deposit", "bar", 100)
+	assert.Error(t, err)
+	assert.NoError(t, s.End())
+	async.Close()
+	assert.Equal(t, uint64(0), async.Dropped())
+
+	types := []saga.LogType{
+		saga.SagaStart, saga.ActionStart, saga.ActionEnd, saga.ActionStart, saga.ActionFailure, saga.SagaAbort,
+		saga.CompensateStart, saga.CompensateEnd, saga.SagaEnd,
+	}
+	assert.Equal(t, len(types), len(events))
+	assert.Equal(t, events, asyncEvents)
+	for i, event := range events {
+		assert.Equal(t, types[i], event.Type)
+		assert.Equal(t, sagaID, event.SagaID)
+		if event.Type == saga.ActionFailure {
+			assert.Equal(t, "deposit", event.SubTxID)
+			assert.EqualError(t, event.Err, "Deposit failure")
+		}
+	}
+
+}
+
+func TestMetrics(t *testing.T) {
+
+	initIt(OK)
+
+	reg := metrics.NewRegistry()
+	sec := saga.NewSEC(memory.New())
+	sec.AddSubTxDef("deduce", DeduceAccount, CompensateDeduce)
+	sec.AddSubTxDef("deposit", DepositAccount, CompensateDeposit)
+	sec.EnableMetrics(reg)
+
+	ctx := context.Background()
+
+	sec.StartSaga(ctx, 17).ExecSub("deduce", "foo", 100).ExecSub("deposit", "bar", 100).EndSaga()
+	testMode = DepositFail
+	sec.StartSaga(ctx, 18).ExecSub("deduce", "foo", 100).ExecSub("deposit", "bar", 100).EndSaga()
+
+	rec := httptest.NewRecorder()
+	reg.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	assert.Equal(t, metrics.ContentType, rec.Header().Get("Content-Type"))
+	for _, line := range []string{
+		"saga_started_total 2",
+		"saga_completed_total 1",
+		"saga_aborted_total 1",
+		`saga_subtx_duration_seconds_count{subtx_id="deduce",phase="action"} 2`,
+		`saga_subtx_duration_seconds_count{subtx_id="deduce",phase="compensate"} 1`,
+		`saga_subtx_failures_total{subtx_id="deposit",phase="action"} 1`,
+		`saga_storage_duration_seconds_count{op="Lookup"} 1`,
+	} {
+		assert.Contains(t, body, line+"\n")
+	}
+	assert.True(t, strings.HasSuffix(body, "# EOF\n"))
+
+}
+
+type spanKey struct{}
+
+type testSpan struct {
+	mu     *sync.Mutex
+	name   string
+	id     string
+	parent string
+	attrs  map[string]interface{}
+	links  []saga.SpanContext
+	errs   []error
+	ended  bool
+}
+
+func (s *testSpan) SetAttributes(attrs ...saga.Attribute) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, attr := range attrs {
+		s.attrs[attr.Key] = attr.Value
+	}
+}
+
+func (s *testSpan) RecordError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errs = append(s.errs, err)
+}
+
+func (s *testSpan) End() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ended = true
+}
+
+func (s *testSpan) SpanContext() saga.SpanContext {
+	return saga.SpanContext{TraceID: "trace", SpanID: s.id}
+}
+
+type testTracer struct {
+	mu    sync.Mutex
+	spans []*testSpan
+}
+
+func (t *testTracer) Start(ctx context.Context, name string, cfg saga.SpanConfig) (context.Context, saga.Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	span := &testSpan{
+		mu:    &t.mu,
+		name:  name,
+		id:    fmt.Sprint(len(t.spans) + 1),
+		attrs: make(map[string]interface{}),
+		links: cfg.Links,
+	}
+	if parent, ok := ctx.Value(spanKey{}).(*testSpan); ok {
+		span.parent = parent.id
+	}
+	for _, attr := range cfg.Attributes {
+		span.attrs[attr.Key] = attr.Value
+	}
+	t.spans = append(t.spans, span)
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+func TestTracing(t *testing.T) {
+
+	initIt(OK)
+
+	tracer := &testTracer{}
+	sec := saga.NewSEC(memory.New())
+	sec.SetTracer(tracer)
+	traced := func(ctx context.Context, account string, amount int) error {
+		span, ok := ctx.Value(spanKey{}).(*testSpan)
+		assert.True(t, ok)
+		assert.Equal(t, "action traced", span.name)
+		return DeduceAccount(ctx, account, amount)
+	}
+	sec.AddSubTxDef("traced", traced, CompensateDeduce)
+	sec.AddSubTxDef("deposit", DepositAccount, CompensateDeposit)
+
+	ctx := context.Background()
+
+	testMode = DepositFail
+	s := sec.StartSaga(ctx, 19)
+	assert.NoError(t, s.Exec("traced", "foo", 100))
+	assert.Error(t, s.Exec("deposit", "bar", 100))
+	assert.NoError(t, s.End())
+
+	names := []string{"saga", "action traced", "action deposit", "compensate traced"}
+	assert.Equal(t, len(names), len(tracer.spans))
+	for i, span := range tracer.spans {
+		assert.Equal(t, names[i], span.name)
+		assert.True(t, span.ended)
+		assert.Equal(t, uint64(19), span.attrs["saga.id"])
+		if i > 0 {
+			assert.Equal(t, "1", span.parent)
+			assert.Equal(t, 1, span.attrs["saga.attempt"])
+		}
+	}
+	assert.Equal(t, true, tracer.spans[0].attrs["saga.aborted"])
+	assert.Equal(t, "deposit", tracer.spans[2].attrs["saga.subtx_id"])
+	assert.EqualError(t, tracer.spans[2].errs[0], "Deposit failure")
+
+	testMode = OK
+	tracer.spans = nil
+	sec.StartSaga(ctx, 20).ExecSub("traced", "foo", 100)
+	results, err := sec.StartCoordinator()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(results))
+	assert.Equal(t, saga.RecoveryCompensated, results[0].Outcome)
+	assert.Equal(t, 4, len(tracer.spans))
+	recovery := tracer.spans[2]
+	assert.Equal(t, "saga.recover", recovery.name)
+	assert.Equal(t, []saga.SpanContext{{TraceID: "trace", SpanID: "1"}}, recovery.links)
+	assert.True(t, recovery.ended)
+	assert.Equal(t, "compensate traced", tracer.spans[3].name)
+	assert.Equal(t, recovery.id, tracer.spans[3].parent)
+
+}
+
+func TestLease(t *testing.T) {
+
+	initIt(OK)
+
+	store := memory.New()
+	leases := lease.NewStorage(store)
+	secA, secB := saga.NewSEC(store), saga.NewSEC(store)
+	for _, sec := range []*saga.ExecutionCoordinator{&secA, &secB} {
+		sec.AddSubTxDef("deduce", DeduceAccount, CompensateDeduce)
+	}
+	secA.EnableLeases(leases, "a", time.Second)
+	secB.EnableLeases(leases, "b", time.Second)
+
+	ctx := context.Background()
+
+	s, err := secA.Start(ctx, 21)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Exec("deduce", "foo", 100))
+
+	_, err = secB.Start(ctx, 21)
+	assert.Equal(t, lease.ErrLeaseHeld, err)
+	results, err := secB.StartCoordinator()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(results))
+	assert.Equal(t, saga.RecoverySkipped, results[0].Outcome)
+
+	// saga in flight on same coordinator
+	results, err = secA.StartCoordinator()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(results))
+	assert.Equal(t, saga.RecoverySkipped, results[0].Outcome)
+	assert.Equal(t, 100, memDB["foo"])
+
+	// saga of crashed peer which holds no lease
+	crashed := saga.NewSEC(store)
+	crashed.AddSubTxDef("deduce", DeduceAccount, CompensateDeduce)
+	crashed.StartSaga(ctx, 22).ExecSub("deduce", "foo", 50)
+	assert.Equal(t, 50, memDB["foo"])
+
+	results, err = secB.StartCoordinator()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(results))
+	for _, result := range results {
+		if result.SagaID == 22 {
+			assert.Equal(t, saga.RecoveryCompensated, result.Outcome)
+		} else {
+			assert.Equal(t, saga.RecoverySkipped, result.Outcome)
+		}
+	}
+	assert.Equal(t, 100, memDB["foo"])
+
+	assert.NoError(t, s.End())
+	_, err = leases.Acquire("saga_21", "b", time.Second)
+	assert.NoError(t, err)
+
+	secC := saga.NewSEC(store)
+	assert.Panics(t, func() { secC.EnableLeases(leases, "c", 0) })
+
+}
+
+func TestDefinePointerArgs(t *testing.T) {
+
+	initIt(OK)
+
+	sec := saga.NewSEC(memory.New())
+	tags := []string{}
+	saga.Define(&sec, "tag",
+		func(ctx context.Context, names []string) error {
+			tags = append(tags, names...)
+			return nil
+		},
+		func(ctx context.Context, names []string) error {
+			tags = tags[:0]
+			return nil
+		})
+	deduce := saga.Define(&sec, "pointerDeduce",
+		func(ctx context.Context, tr *Transfer) error {
+			return DeduceAccount(ctx, tr.Account, tr.Amount)
+		},
+		func(ctx context.Context, tr *Transfer) error {
+			return CompensateDeduce(ctx, tr.Account, tr.Amount)
+		})
+
+	ctx := context.Background()
+
+	var sagaID uint64 = 23
+	s, err := sec.Start(ctx, sagaID)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Exec("tag", []string{"a", "b"}))
+	assert.NoError(t, deduce.Exec(s, &Transfer{Account: "foo", Amount: 100}))
+	assert.Equal(t, 100, memDB["foo"])
+
+	status, err := sec.SagaStatus(sagaID)
+	assert.NoError(t, err)
+	assert.Equal(t, "[]string", status.Steps[0].Params[0].ParamType)
+	assert.Equal(t, "*saga_test.Transfer", status.Steps[1].Params[0].ParamType)
+
+	results, err := sec.StartCoordinator()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(results))
+	assert.Equal(t, saga.RecoveryCompensated, results[0].Outcome)
+	assert.NoError(t, results[0].Err)
+	assert.Equal(t, 200, memDB["foo"])
+	assert.Empty(t, tags)
+
+	noop := func(ctx context.Context, tr Transfer) error { return nil }
+	saga.Define(&sec, "typed", noop, noop)
+	type Transfer struct{}
+	assert.Panics(t, func() {
+		saga.Define(&sec, "conflict",
+			func(ctx context.Context, tr Transfer) error { return nil },
+			func(ctx context.Context, tr Transfer) error { return nil })
+	})
+
+}
+
+func TestForwardResult(t *testing.T) {
+
+	initIt(OK)
+
+	sec := saga.NewSEC(memory.New())
+	attempts := []int{}
+	sec.Use(func(next saga.Handler) saga.Handler {
+		return func(ctx context.Context, inv *saga.Invocation) error {
+			if inv.SubTxID == "notify" {
+				attempts = append(attempts, inv.Attempt)
+			}
+			return next(ctx, inv)
+		}
+	})
+	charge := func(ctx context.Context, account string, amount int) (string, error) {
+		memDB[account] = (memDB[account] - amount)
+		return "pay-" + account, nil
+	}
+	available := false
+	notified := ""
+	notify := func(ctx context.Context, account string) error {
+		s, ok := saga.FromContext(ctx)
+		if !ok {
+			return fmt.Errorf("Saga not found")
+		}
+		if err := s.Result("charge", &notified); err != nil {
+			return err
+		}
+		if !available {
+			return fmt.Errorf("Notify failure")
+		}
+		return nil
+	}
+	noop := func(ctx context.Context, account string) error { return nil }
+	sec.AddSubTxDef("charge", charge, CompensateDeduce, saga.WithKind(saga.Pivot))
+	sec.AddSubTxDef("notify", notify, noop, saga.WithKind(saga.Retriable),
+		saga.WithRetry(saga.RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond}))
+
+	ctx := context.Background()
+
+	var sagaID uint64 = 24
+	s, err := sec.Start(ctx, sagaID)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Exec("charge", "foo", 100))
+	assert.Error(t, s.Exec("notify", "foo"))
+	assert.Equal(t, "pay-foo", notified)
+
+	status, err := sec.SagaStatus(sagaID)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, status.Steps[1].Attempts)
+
+	notified = ""
+	available = true
+	results, err := sec.StartCoordinator()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(results))
+	assert.Equal(t, saga.RecoveryCompleted, results[0].Outcome)
+	assert.NoError(t, results[0].Err)
+	assert.Equal(t, "pay-foo", notified)
+	assert.Equal(t, []int{1, 2, 3}, attempts)
+
+}
+
+func TestRecoverCompensateAttempts(t *testing.T) {
+
+	initIt(OK)
+
+	store := memory.New()
+	sec := saga.NewSEC(store)
+	attempts := []int{}
+	sec.Use(func(next saga.Handler) saga.Handler {
+		return func(ctx context.Context, inv *saga.Invocation) error {
+			if inv.SubTxID == "stuck" && inv.Phase == saga.PhaseCompensate {
+				attempts = append(attempts, inv.Attempt)
+			}
+			return next(ctx, inv)
+		}
+	})
+	failCompensate := func(ctx context.Context, account string, amount int) error {
+		return fmt.Errorf("Compensate failure")
+	}
+	failAction := func(ctx context.Context, account string, amount int) error {
+		return fmt.Errorf("Action failure")
+	}
+	sec.AddSubTxDef("stuck", DeduceAccount, failCompensate,
+		saga.WithCompensateRetry(saga.RetryPolicy{MaxAttempts: 3}))
+	sec.AddSubTxDef("fail", failAction, CompensateDeposit)
+
+	ctx := context.Background()
+
+	var sagaID uint64 = 25
+	s, err := sec.Start(ctx, sagaID)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Exec("stuck", "foo", 100))
+	var compensateErr *saga.CompensateError
+	assert.True(t, errors.As(s.Exec("fail", "bar", 100), &compensateErr))
+	assert.Equal(t, 3, compensateErr.Attempts)
+	assert.Equal(t, []int{1, 2, 3}, attempts)
+
+	// crash before the last attempt is logged, recovery only has one attempt left
+	logs, err := store.Lookup("saga_25")
+	assert.NoError(t, err)
+	assert.NoError(t, store.Cleanup("saga_25"))
+	for _, data := range logs[:len(logs)-2] {
+		assert.NoError(t, store.AppendLog("saga_25", data))
+	}
+	results, err := sec.StartCoordinator()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(results))
+	assert.Equal(t, saga.RecoveryStuck, results[0].Outcome)
+	assert.Equal(t, []int{1, 2, 3, 3}, attempts)
+
+	// recovery never calls compensate again once attempts used up
+	logs, err = store.Lookup("saga_25")
+	assert.NoError(t, err)
+	assert.NoError(t, store.Cleanup("saga_25"))
+	for _, data := range logs[:len(logs)-1] {
+		assert.NoError(t, store.AppendLog("saga_25", data))
+	}
+	results, err = sec.StartCoordinator()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(results))
+	assert.Equal(t, saga.RecoveryStuck, results[0].Outcome)
+	assert.True(t, errors.As(results[0].Err, &compensateErr))
+	assert.Equal(t, 3, compensateErr.Attempts)
+	assert.Equal(t, []int{1, 2, 3, 3}, attempts)
+	assert.NoError(t, store.Cleanup("saga_25"))
+
+}
@@ -0,0 +1,120 @@
+package saga
+
+import (
+	"golang.org/x/net/context"
+)
+
+// Tracer starts spans for sagas and sub-transactions.
+// It follows shape of OpenTelemetry tracer, so an adapter over OpenTelemetry can be plugged in by SetTracer.
+type Tracer interface {
+	// Start starts a span which is child of span in ctx if any, and returns ctx carries the new span.
+	Start(ctx context.Context, name string, cfg SpanConfig) (context.Context, Span)
+}
+
+// Span presents a traced operation.
+type Span interface {
+	SetAttributes(attrs ...Attribute)
+	RecordError(err error)
+	End()
+	SpanContext() SpanContext
+}
+
+// SpanConfig presents options to start span.
+type SpanConfig struct {
+	Attributes []Attribute
+	// Links presents spans related to new span, like the original saga span of a recovery.
+	Links []SpanContext
+}
+
+// Attribute presents a key-value attribute of span.
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// SpanContext identifies a span, it's persisted in saga log to link spans on recovery.
+type SpanContext struct {
+	TraceID string `json:"traceID,omitempty"`
+	SpanID  string `json:"spanID,omitempty"`
+}
+
+// IsValid returns whether span context identifies a span.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != "" && sc.SpanID != ""
+}
+
+// SetTracer sets tracer of Default SEC.
+func SetTracer(tracer Tracer) *ExecutionCoordinator {
+	return DefaultSEC.SetTracer(tracer)
+}
+
+// SetTracer sets tracer of SEC and return current SEC, sagas are not traced if tracer is nil.
+//
+// Each saga has a root span from start to end, and each attempt of action and compensate has a child span
+// with saga.id, saga.subtx_id, saga.step_id and saga.attempt attributes, error of attempt is recorded on it.
+// The context passed to action and compensate carries their span.
+// Recovery by StartCoordinator starts a new root span linked to the span of original saga.
+func (e *ExecutionCoordinator) SetTracer(tracer Tracer) *ExecutionCoordinator {
+	e.tracer = tracer
+	return e
+}
+
+func (e *ExecutionCoordinator) getTracer() Tracer {
+	if e.tracer == nil {
+		return noopTracer{}
+	}
+	return e.tracer
+}
+
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, name string, cfg SpanConfig) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttributes(attrs ...Attribute) {}
+
+func (noopSpan) RecordError(err error) {}
+
+func (noopSpan) End() {}
+
+func (noopSpan) SpanContext() SpanContext {
+	return SpanContext{}
+}
+
+// startSpan starts root span of saga, saga context carries the span after that.
+func (s *Saga) startSpan(name string, links ...SpanContext) {
+	cfg := SpanConfig{Attributes: []Attribute{{Key: "saga.id", Value: s.id}}}
+	if s.def != nil {
+		cfg.Attributes = append(cfg.Attributes, Attribute{Key: "saga.name", Value: s.def.name})
+	}
+	for _, link := range links {
+		if link.IsValid() {
+			cfg.Links = append(cfg.Links, link)
+		}
+	}
+	s.context, s.span = s.sec.getTracer().Start(s.context, name, cfg)
+}
+
+// spanContext returns context of saga root span to persist in saga log.
+func (s *Saga) spanContext() *SpanContext {
+	sc := s.span.SpanContext()
+	if !sc.IsValid() {
+		return nil
+	}
+	return &sc
+}
+
+// traceInvocation starts span for invocation of action or compensate.
+func (s *Saga) traceInvocation(ctx context.Context, inv *Invocation) (context.Context, Span) {
+	return s.sec.getTracer().Start(ctx, inv.Phase.String()+" "+inv.SubTxID, SpanConfig{
+		Attributes: []Attribute{
+			{Key: "saga.id", Value: inv.SagaID},
+			{Key: "saga.subtx_id", Value: inv.SubTxID},
+			{Key: "saga.step_id", Value: inv.StepID},
+			{Key: "saga.attempt", Value: inv.Attempt},
+		},
+	})
+}